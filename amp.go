@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ampCachePath matches AMP cache paths such as /c/s/example.com/article;
+	// the "s/" marks an https origin.
+	ampCachePath = regexp.MustCompile(`^/(?:c|v|i|r|wp|a)/(s/)?([^/]+)(/.*)?$`)
+	// googleAMPPath matches the Google AMP viewer, e.g. /amp/s/example.com/article.
+	googleAMPPath = regexp.MustCompile(`^/amp/(s/)?([^/]+)(/.*)?$`)
+	// ampFileSuffix matches publisher AMP pages such as article.amp.html.
+	ampFileSuffix = regexp.MustCompile(`\.amp(\.html?)$`)
+	// ampPathSuffix matches a trailing /amp. On its own that is often a real
+	// page (example.com/tags/amp), so it is only dropped from URLs that show
+	// another AMP signal.
+	ampPathSuffix = regexp.MustCompile(`/amp/?$`)
+)
+
+// deAMP recovers the publisher's canonical URL from Google AMP viewer and AMP
+// cache links and drops .amp.html suffixes and amp=1 markers, plus a trailing
+// /amp on links that came through AMP. It works offline, from the shape of
+// the URL alone.
+func deAMP(rawURL string, res *CleanResult) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	host := u.Hostname()
+	changed := false
+
+	var m []string
+	switch {
+	case hostMatches(host, "google.*"):
+		m = googleAMPPath.FindStringSubmatch(u.Path)
+	case hostMatches(host, "cdn.ampproject.org"):
+		m = ampCachePath.FindStringSubmatch(u.Path)
+	}
+	if m != nil && strings.Contains(m[2], ".") {
+		u.Scheme = "http"
+		if m[1] != "" {
+			u.Scheme = "https"
+		}
+		u.Host, u.Path, u.RawPath = m[2], m[3], ""
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		res.add(ActionAMP, host+" → "+u.Host, "")
+		changed = true
+	}
+
+	// amp=1 (or a bare amp) and usqp only mark AMP renditions; amp=10 is a value
+	q := parseRawQuery(u.RawQuery)
+	marker := ampMarker(q)
+	removed := q.removeIf(func(param string) bool {
+		return param == "usqp" || param == "amp" && marker
+	})
+	for _, param := range removed {
+		res.add(ActionAMP, param, "")
+		changed = true
+	}
+	u.RawQuery = q.String()
+
+	if loc := ampFileSuffix.FindStringSubmatchIndex(u.Path); loc != nil && loc[0] > 0 {
+		res.add(ActionAMP, u.Path[loc[0]:loc[1]], "")
+		u.Path, u.RawPath = u.Path[:loc[0]]+u.Path[loc[2]:loc[3]], ""
+		changed = true
+	} else if loc := ampPathSuffix.FindStringIndex(u.Path); loc != nil && loc[0] > 0 && changed {
+		// The viewer, the cache or an amp=1 marker already showed this is an AMP link
+		res.add(ActionAMP, u.Path[loc[0]:loc[1]], "")
+		u.Path, u.RawPath = u.Path[:loc[0]], ""
+	}
+
+	if !changed {
+		return rawURL
+	}
+	return urlString(u)
+}
+
+// ampMarker reports whether every amp parameter in q is "amp", "amp=" or
+// "amp=1", the forms publishers use to request the AMP rendition.
+func ampMarker(q rawQuery) bool {
+	for _, p := range q {
+		if p.key != "amp" {
+			continue
+		}
+		if _, value, _ := strings.Cut(p.raw, "="); value != "" && value != "1" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// IDN policies for internationalized host names.
+const (
+	IDNKeep     = ""         // leave hosts as copied
+	IDNUnicode  = "unicode"  // show readable Unicode (bücher.de)
+	IDNPunycode = "punycode" // force ASCII (xn--bcher-kva.de)
+)
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// canonicalizeURL removes cosmetic differences between copies of the same
+// link: scheme and host case, default ports, "." and ".." path segments, an
+// empty trailing "?" and, depending on idnPolicy, the host encoding.
+func canonicalizeURL(rawURL, idnPolicy string, res *CleanResult) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	changed := false
+
+	host, port := u.Hostname(), u.Port()
+	if lower := strings.ToLower(host); lower != host {
+		res.add(ActionCanonical, "lowercased host", "")
+		host, changed = lower, true
+	}
+	if port != "" && defaultPorts[u.Scheme] == port {
+		res.add(ActionCanonical, "removed default port :"+port, "")
+		port, changed = "", true
+	}
+	if converted := convertIDN(host, idnPolicy); converted != host {
+		res.add(ActionCanonical, host+" → "+converted, "")
+		host, changed = converted, true
+	}
+	if changed {
+		u.Host = joinHostPort(host, port)
+	}
+
+	if escaped := u.EscapedPath(); strings.Contains(escaped, ".") {
+		if resolved := removeDotSegments(escaped); resolved != escaped {
+			if unescaped, err := url.PathUnescape(resolved); err == nil {
+				res.add(ActionCanonical, "resolved "+escaped+" → "+resolved, "")
+				u.Path, u.RawPath = unescaped, resolved
+				changed = true
+			}
+		}
+	}
+
+	if u.ForceQuery && u.RawQuery == "" {
+		res.add(ActionCanonical, "removed empty query", "")
+		u.ForceQuery = false
+		changed = true
+	}
+
+	// url.Parse already lowercases the scheme; keep the result if that was the only change.
+	if !changed && !strings.HasPrefix(rawURL, u.Scheme+":") {
+		res.add(ActionCanonical, "lowercased scheme", "")
+		changed = true
+	}
+
+	if !changed {
+		return rawURL
+	}
+	return urlString(u)
+}
+
+func convertIDN(host, policy string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	var converted string
+	var err error
+	switch policy {
+	case IDNUnicode:
+		converted, err = hostToUnicode(host)
+	case IDNPunycode:
+		converted, err = hostToASCII(host)
+	default:
+		return host
+	}
+	if err != nil {
+		return host
+	}
+	return converted
+}
+
+func joinHostPort(host, port string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port == "" {
+		return host
+	}
+	return host + ":" + port
+}
+
+// removeDotSegments implements RFC 3986 section 5.2.4 on an escaped path.
+func removeDotSegments(path string) string {
+	var out []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	resolved := strings.Join(out, "/")
+	if strings.HasPrefix(path, "/") && !strings.HasPrefix(resolved, "/") {
+		resolved = "/" + resolved
+	}
+	return resolved
+}
+
+// urlString is u.String() without percent-encoding a Unicode host, so links
+// kept in (or converted to) Unicode stay readable.
+func urlString(u *url.URL) string {
+	s := u.String()
+	if isASCII(u.Host) {
+		return s
+	}
+	escaped := strings.TrimPrefix((&url.URL{Host: u.Host}).String(), "//")
+	return strings.Replace(s, "//"+escaped, "//"+u.Host, 1)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Rule categories. Every rule belongs to exactly one; the global blocklist
+// and rules without a category count as tracking.
+const (
+	CategoryTracking    = "tracking"     // click and campaign IDs (fbclid, gclid)
+	CategoryAffiliate   = "affiliate"    // referral and affiliate tags (tag=, ref=)
+	CategorySocialShare = "social-share" // share markers added by apps (si=, igshid=)
+	CategoryAnalytics   = "analytics"    // campaign analytics (utm_*, _ga)
+)
+
+// RuleCategories lists the categories in the order they appear in the tray.
+var RuleCategories = []string{CategoryTracking, CategoryAffiliate, CategorySocialShare, CategoryAnalytics}
+
+func validCategory(category string) (string, error) {
+	if category == "" {
+		return CategoryTracking, nil
+	}
+	if !slices.Contains(RuleCategories, category) {
+		return "", fmt.Errorf("unknown category %q", category)
+	}
+	return category, nil
+}
+
+// withoutCategories drops the rules whose category is disabled.
+func withoutCategories(rules []*compiledRule, disabled []string) []*compiledRule {
+	if len(disabled) == 0 {
+		return rules
+	}
+	return slices.DeleteFunc(rules, func(r *compiledRule) bool {
+		return slices.Contains(disabled, r.category)
+	})
+}
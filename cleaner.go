@@ -1,64 +1,188 @@
 package main
 
 import (
-	"net/http"
+	"context"
+	"errors"
+	"net/netip"
 	"net/url"
 	"strings"
-	"time"
 	"unicode"
 )
 
+// CleanOptions selects the optional stages CleanText applies.
+type CleanOptions struct {
+	Unshorten  bool
+	WSLMode    bool
+	DirectLink bool
+	// DetectShortLinks also unshortens links that look shortened but are not
+	// on a listed shortener.
+	DetectShortLinks bool
+	// AllowedNetworks are private address ranges unshortening may connect to.
+	AllowedNetworks []netip.Prefix
+	// Offline unshortens only links found in the unshorten cache; the
+	// result's NeedsNetwork reports whether any were skipped.
+	Offline bool
+	// EmbeddedLinks cleans every URL found inside free-form text.
+	EmbeddedLinks bool
+	// NeverClean lists hosts the user exempted from cleaning.
+	NeverClean []string
+	// StripTextFragments drops Scroll-To-Text (#:~:text=) highlights.
+	StripTextFragments bool
+	// DeAMP replaces AMP viewer, AMP cache and /amp links with the publisher URL.
+	DeAMP bool
+	// IDNPolicy selects how internationalized hosts are written (IDNKeep, IDNUnicode, IDNPunycode).
+	IDNPolicy string
+	// CredentialPolicy selects what happens to embedded credentials (CredentialStrip, CredentialKeep, CredentialWarn); empty keeps them.
+	CredentialPolicy string
+	// DisabledCategories lists rule categories to leave alone, e.g. to keep affiliate tags.
+	DisabledCategories []string
+
+	// rules overrides the active rule set, so rules can be tried out before they are applied.
+	rules *ruleSet
+}
+
+func (o CleanOptions) ruleSet() *ruleSet {
+	if o.rules != nil {
+		return o.rules
+	}
+	return currentRules()
+}
+
 // CleanText processes input for Privacy, Cloud links, and Path normalization.
-func CleanText(input string, unshorten bool, wslMode bool, cloudBoost bool) string {
+func CleanText(input string, opts CleanOptions) string {
+	res := Clean(input, opts)
+	return res.Output
+}
+
+// Clean is CleanText with an explanation of every change it made.
+func Clean(input string, opts CleanOptions) CleanResult {
+	return CleanContext(context.Background(), input, opts)
+}
+
+// CleanContext is Clean with unshortening given up when ctx is done.
+func CleanContext(ctx context.Context, input string, opts CleanOptions) CleanResult {
+	res := CleanResult{Input: input, Output: input}
 	trimmed := strings.TrimSpace(input)
 
 	// 1. Path Detection
 	if isWindowsPath(trimmed) {
-		return processPath(trimmed, wslMode)
+		res.Output = processPath(trimmed, opts.WSLMode)
+		if res.Output != input {
+			res.add(ActionPath, trimmed+" → "+res.Output, "")
+		}
+		return res
+	}
+
+	// 2. Links inside text (chat messages, Markdown, e-mails)
+	if opts.EmbeddedLinks && !isSingleURL(trimmed) {
+		res.Output = cleanEmbeddedURLs(ctx, input, opts, &res)
+		res.warnLookalike(linkTextWarnings(input)...)
+		return res
+	}
+
+	// 3. URL Cleaning
+	if !hasHTTPPrefix(trimmed) {
+		return res
+	}
+	cleaned := cleanURL(ctx, trimmed, opts, &res)
+	if cleaned != trimmed {
+		res.Output = cleaned
 	}
+	res.warnLookalike(hostWarnings(hostOf(cleaned))...)
+	return res
+}
+
+// cleanURL runs the URL pipeline on a single link, recording changes in res.
+func cleanURL(ctx context.Context, trimmed string, opts CleanOptions, res *CleanResult) string {
+	rs := opts.ruleSet()
 
-	// 2. URL Cleaning
-	if !strings.HasPrefix(trimmed, "http") {
-		return input
+	// Exempted hosts are left exactly as copied, apart from the user's own
+	// rules when only a subscription exempts them
+	if exempt, byUser := rs.exemption(trimmed, opts); exempt {
+		if byUser {
+			return trimmed
+		}
+		return rs.userRules().cleanParams(trimmed, opts, res)
 	}
 
-	finalURL := trimmed
+	// Unwrap redirect wrappers (Google, Facebook, Safe Links...) offline
+	finalURL := rs.unwrapRedirects(trimmed, res)
+
+	// Recover the publisher URL from AMP links
+	if opts.DeAMP {
+		finalURL = deAMP(finalURL, res)
+	}
 
 	// Unshorten logic
-	if unshorten && isShortLink(trimmed) {
-		resolved := resolveURL(trimmed)
-		if resolved != "" && resolved != trimmed {
-			finalURL = resolved
+	if opts.Unshorten {
+		// The reason names the shortener entry or heuristic that sent the request
+		if reason, short := rs.shortLink(finalURL, opts.DetectShortLinks); short {
+			resolved, err := resolveCached(ctx, finalURL, opts.AllowedNetworks, opts.Offline)
+			var blocked *errBlockedAddress
+			if errors.Is(err, errNotCached) {
+				res.unresolved = true
+			} else if errors.As(err, &blocked) {
+				res.warn(hostOf(finalURL) + " link leads to private address " + blocked.addr.String() + " and was not followed")
+			} else if err == nil && resolved != finalURL {
+				res.add(ActionUnshortened, hostOf(finalURL)+" → "+hostOf(resolved), reason)
+				finalURL = rs.unwrapRedirects(resolved, res)
+			}
+		}
+	}
+
+	// Normalize case, default ports, dot segments and the host encoding
+	finalURL = canonicalizeURL(finalURL, opts.IDNPolicy, res)
+
+	// The unwrapped or resolved destination may itself be exempt
+	if exempt, byUser := rs.exemption(finalURL, opts); exempt {
+		if byUser {
+			return finalURL
 		}
+		return rs.userRules().cleanParams(finalURL, opts, res)
 	}
 
+	// Provider raw rules
+	finalURL = rs.rewriteURL(finalURL, opts.DisabledCategories, res)
+
 	u, err := url.Parse(finalURL)
 	if err != nil {
 		return finalURL
 	}
 
-	// Remove tracking parameters using dynamic blocklist
-	BlocklistLock.RLock()
-	currentParams := ActiveBlocklist
-	BlocklistLock.RUnlock()
+	origPath, origQuery, origFragment := u.Path, u.RawQuery, u.EscapedFragment()
+	hadUser := u.User != nil
 
-	q := u.Query()
-	for _, param := range currentParams {
-		q.Del(param)
-	}
+	// Remove tracking parameters using dynamic blocklist.
+	// The query is edited in place so untouched pairs keep their order and encoding.
+	q := parseRawQuery(u.RawQuery)
+	rules := rs.rulesFor(finalURL, u.Hostname(), opts.DisabledCategories)
+	q.removeIf(func(param string) bool {
+		return rs.stripParam(u, rules, param, res)
+	})
+
+	// Embedded credentials and secret-looking parameters
+	rs.handleCredentials(u, &q, opts.CredentialPolicy, res)
+
+	// Query-like fragments (#utm_source=..., #/route?fbclid=...) and text highlights
+	rs.cleanFragment(u, rules, opts, res)
+
+	// Path rewrites (Amazon /ref=, ;jsessionid=...)
+	rs.rewritePath(u, res)
 
 	// Fix YouTube Shorts
 	if strings.Contains(u.Host, "youtube.com") && strings.Contains(u.Path, "/shorts/") {
 		videoID := strings.TrimPrefix(u.Path, "/shorts/")
 		u.Path = "/watch"
-		q.Set("v", videoID)
+		q.set("v", videoID)
+		res.add(ActionShorts, videoID, "")
 	}
 
-	// 3. Cloud Booster (Dropbox & Google Drive)
-	if cloudBoost {
+	// Cloud Booster (Dropbox & Google Drive)
+	if opts.DirectLink {
 		// Automatically convert to direct download links
 		if strings.Contains(u.Host, "dropbox.com") {
-			q.Set("dl", "1")
+			q.set("dl", "1")
+			res.add(ActionDirectLink, "Dropbox", "")
 		} else if strings.Contains(u.Host, "drive.google.com") && strings.Contains(u.Path, "/view") {
 			// Convert /file/d/ID/view -> /uc?export=download&id=ID
 			parts := strings.Split(u.Path, "/")
@@ -66,16 +190,64 @@ func CleanText(input string, unshorten bool, wslMode bool, cloudBoost bool) stri
 				if part == "d" && i+1 < len(parts) {
 					id := parts[i+1]
 					u.Path = "/uc"
-					q.Set("export", "download")
-					q.Set("id", id)
+					q.set("export", "download")
+					q.set("id", id)
+					res.add(ActionDirectLink, "Google Drive", "")
 					break
 				}
 			}
 		}
 	}
 
-	u.RawQuery = q.Encode()
-	return u.String()
+	u.RawQuery = q.String()
+	if finalURL == trimmed && u.Path == origPath && u.RawQuery == origQuery &&
+		u.EscapedFragment() == origFragment && (u.User != nil) == hadUser {
+		return trimmed // Nothing matched: keep the link byte-for-byte
+	}
+	return urlString(u)
+}
+
+// stripParam reports whether param must be removed from u and records the
+// removal in res.
+func (rs *ruleSet) stripParam(u *url.URL, rules []*compiledRule, param string, res *CleanResult) bool {
+	rule, desc := matchParam(rules, param)
+	if rule == nil {
+		return false
+	}
+	if _, kept := rs.keptParam(u, param, rule.user); kept {
+		return false
+	}
+	res.add(ActionRemovedParam, param, desc)
+	return true
+}
+
+// cleanParams applies only the raw rules and parameters of rs to rawURL,
+// in the query and in query-like fragments. It cleans links exempted by a
+// subscription with the user's own rules.
+func (rs *ruleSet) cleanParams(rawURL string, opts CleanOptions, res *CleanResult) string {
+	if len(rs.rules) == 0 {
+		return rawURL
+	}
+	cleaned := rs.rewriteURL(rawURL, opts.DisabledCategories, res)
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return cleaned
+	}
+	origQuery, origFragment := u.RawQuery, u.EscapedFragment()
+
+	q := parseRawQuery(u.RawQuery)
+	rules := rs.rulesFor(cleaned, u.Hostname(), opts.DisabledCategories)
+	q.removeIf(func(param string) bool {
+		return rs.stripParam(u, rules, param, res)
+	})
+	opts.StripTextFragments = false
+	rs.cleanFragment(u, rules, opts, res)
+
+	u.RawQuery = q.String()
+	if cleaned == rawURL && u.RawQuery == origQuery && u.EscapedFragment() == origFragment {
+		return rawURL
+	}
+	return urlString(u)
 }
 
 func processPath(input string, wslMode bool) string {
@@ -114,44 +286,3 @@ func isWindowsPath(s string) bool {
 	}
 	return false
 }
-
-func resolveURL(shortURL string) string {
-	client := &http.Client{
-		Timeout: 3 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return http.ErrUseLastResponse
-			}
-			return nil
-		},
-	}
-	resp, err := client.Head(shortURL)
-	if err != nil {
-		resp, err = client.Get(shortURL)
-		if err != nil {
-			return ""
-		}
-	}
-	defer resp.Body.Close()
-	return resp.Request.URL.String()
-}
-
-func isShortLink(input string) bool {
-	u, err := url.Parse(input)
-	if err != nil {
-		return false
-	}
-	shorteners := []string{
-		"bit.ly", "goo.gl", "t.co", "tinyurl.com", "is.gd",
-		"buff.ly", "amzn.to", "lnkd.in", "rebrand.ly", "shrtco.de",
-	}
-	for _, s := range shorteners {
-		if u.Host == s || strings.HasSuffix(u.Host, s) {
-			return true
-		}
-	}
-	if len(input) < 30 && !strings.Contains(u.Host, "localhost") && !strings.Contains(u.Host, "127.0.0.1") {
-		return true
-	}
-	return false
-}
\ No newline at end of file
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+)
+
+// clearURLsData mirrors the ClearURLs data.min.json rule database.
+type clearURLsData struct {
+	Providers map[string]clearURLsProvider `json:"providers"`
+}
+
+type clearURLsProvider struct {
+	URLPattern        string   `json:"urlPattern"`
+	CompleteProvider  bool     `json:"completeProvider"`
+	Rules             []string `json:"rules"`
+	RawRules          []string `json:"rawRules"`
+	ReferralMarketing []string `json:"referralMarketing"`
+	Exceptions        []string `json:"exceptions"`
+	Redirections      []string `json:"redirections"`
+	ForceRedirection  bool     `json:"forceRedirection"`
+}
+
+// toRuleConfig translates every provider into a ScopedRule. ClearURLs
+// patterns are JavaScript regular expressions evaluated case-insensitively;
+// the few that RE2 cannot compile (lookarounds, backreferences) are dropped
+// instead of rejecting the whole database. Complete providers, which block a
+// site outright, have no clipboard equivalent and only contribute their rules.
+// Referral marketing parameters become a separate affiliate rule.
+func (d *clearURLsData) toRuleConfig() *RuleConfig {
+	names := make([]string, 0, len(d.Providers))
+	for name := range d.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	config := &RuleConfig{Blocklist: []string{}}
+	for _, name := range names {
+		p := d.Providers[name]
+		rule := ScopedRule{
+			Name:         name,
+			URLPattern:   clearURLsRegexp(p.URLPattern),
+			RawRules:     clearURLsRegexps(p.RawRules),
+			Exceptions:   clearURLsRegexps(p.Exceptions),
+			Redirections: clearURLsRegexps(p.Redirections),
+		}
+		if p.URLPattern != "" && rule.URLPattern == "" {
+			continue // Unsupported scope; applying it everywhere would be wrong
+		}
+		rule.Params = clearURLsParams(p.Rules)
+		config.Rules = append(config.Rules, rule)
+
+		// Referral marketing gets its own rule so it can be toggled as affiliate tags.
+		if referral := clearURLsParams(p.ReferralMarketing); len(referral) > 0 {
+			config.Rules = append(config.Rules, ScopedRule{
+				Name:       name + " referral",
+				Category:   CategoryAffiliate,
+				URLPattern: rule.URLPattern,
+				Params:     referral,
+				Exceptions: rule.Exceptions,
+			})
+		}
+	}
+	return config
+}
+
+// clearURLsParams converts ClearURLs parameter patterns to "re:" entries.
+func clearURLsParams(exprs []string) []string {
+	params := []string{}
+	for _, expr := range clearURLsRegexps(exprs) {
+		params = append(params, "re:"+expr)
+	}
+	return params
+}
+
+// clearURLsRegexp converts a ClearURLs pattern to a case-insensitive Go
+// regular expression, or returns "" if RE2 does not support it.
+func clearURLsRegexp(expr string) string {
+	if expr == "" {
+		return ""
+	}
+	expr = "(?i)" + expr
+	if _, err := regexp.Compile(expr); err != nil {
+		return ""
+	}
+	return expr
+}
+
+func clearURLsRegexps(exprs []string) []string {
+	var res []string
+	for _, expr := range exprs {
+		if converted := clearURLsRegexp(expr); converted != "" {
+			res = append(res, converted)
+		}
+	}
+	return res
+}
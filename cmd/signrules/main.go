@@ -0,0 +1,72 @@
+// Command signrules signs rule files for PureLink's filter updates.
+//
+//	signrules -genkey -key release.key    create a key pair, print the public key
+//	signrules -key release.key rules.json write rules.json.sig
+//
+// The key file holds the base64 ed25519 seed and must stay private; never
+// commit it. The printed public key goes into releaseKey in signing.go for
+// the official rules, or into "trusted_keys" in purelink_config.json for a
+// self-hosted rule source.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	keyFile := flag.String("key", "release.key", "private key file")
+	genKey := flag.Bool("genkey", false, "generate a new key pair")
+	flag.Parse()
+
+	if err := run(*keyFile, *genKey, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "signrules:", err)
+		os.Exit(1)
+	}
+}
+
+func run(keyFile string, genKey bool, files []string) error {
+	if genKey {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		seed := base64.StdEncoding.EncodeToString(priv.Seed())
+		if err := os.WriteFile(keyFile, []byte(seed+"\n"), 0o600); err != nil {
+			return err
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(pub))
+		return nil
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no rule files given")
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("%s is not a signrules key", keyFile)
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+
+	for _, file := range files {
+		rules, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, rules))
+		if err := os.WriteFile(file+".sig", []byte(sig+"\n"), 0o644); err != nil {
+			return err
+		}
+		fmt.Println("signed", file)
+	}
+	return nil
+}
@@ -1,43 +1,101 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"slices"
+	"sync"
 )
 
 type Config struct {
-	Unshorten    bool     `json:"unshorten"`
-	WSLMode      bool     `json:"wsl_mode"`
-	DirectLink   bool     `json:"direct_link"`
-	Sound        bool     `json:"sound"`
-	TotalCleaned int      `json:"total_cleaned"`
-	History      []string `json:"history"`
+	Unshorten          bool           `json:"unshorten"`
+	DetectShortLinks   bool           `json:"detect_short_links"`
+	WSLMode            bool           `json:"wsl_mode"`
+	DirectLink         bool           `json:"direct_link"`
+	DeAMP              bool           `json:"de_amp"`
+	CleanInText        bool           `json:"clean_in_text"`
+	StripTextFragments bool           `json:"strip_text_fragments"`
+	Sound              bool           `json:"sound"`
+	TotalCleaned       int            `json:"total_cleaned"`
+	History            []HistoryEntry `json:"history"`
+	// IDNPolicy is "", "unicode" or "punycode"; see the IDN* constants.
+	IDNPolicy string `json:"idn_policy,omitempty"`
+	// CredentialPolicy is "strip", "keep" or "warn"; see the Credential* constants.
+	CredentialPolicy string `json:"credential_policy"`
+	// NeverClean lists hosts added with "Never Clean This Domain".
+	NeverClean []string `json:"never_clean,omitempty"`
+	// DisabledCategories lists the rule categories switched off in the tray.
+	DisabledCategories []string `json:"disabled_categories,omitempty"`
+	// TrustedKeys are extra base64 ed25519 public keys accepted for rule updates.
+	TrustedKeys []string `json:"trusted_keys,omitempty"`
+	// Subscriptions are the rule sources merged with user_rules.json.
+	Subscriptions []Subscription `json:"subscriptions"`
+	// AutoUpdate refreshes the subscriptions in the background.
+	AutoUpdate bool `json:"auto_update"`
+	// AllowedNetworks are private ranges (CIDR or single addresses) that
+	// unshortening may connect to, e.g. an intranet link shortener.
+	AllowedNetworks []string `json:"allowed_networks,omitempty"`
+}
+
+// HistoryEntry is a recently cleaned item together with what was stripped from it.
+type HistoryEntry struct {
+	CleanResult
+}
+
+// UnmarshalJSON also accepts the plain strings written by older versions.
+func (h *HistoryEntry) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		h.CleanResult = CleanResult{Input: legacy, Output: legacy}
+		return nil
+	}
+	return json.Unmarshal(data, &h.CleanResult)
 }
 
 const configFileName = "purelink_config.json"
 
+// defaultConfig returns the settings used before the user changes anything.
+func defaultConfig() *Config {
+	return &Config{
+		Unshorten:          false,
+		DetectShortLinks:   false,
+		WSLMode:            false,
+		DirectLink:         true,
+		DeAMP:              true,
+		CleanInText:        false,
+		StripTextFragments: false,
+		Sound:              true,
+		TotalCleaned:       0,
+		History:            []HistoryEntry{},
+		CredentialPolicy:   CredentialKeep,
+		Subscriptions:      defaultSubscriptions(),
+		AutoUpdate:         true,
+	}
+}
+
+var (
+	// configData is the config file as last read or written by PureLink, so
+	// the file watcher can tell its own saves from the user's edits.
+	configData      []byte
+	configDataMutex sync.Mutex
+)
+
 func LoadConfig() (*Config, error) {
 	// Default config
-	cfg := &Config{
-		Unshorten:    false,
-		WSLMode:      false,
-		DirectLink:   true,
-		Sound:        true,
-		TotalCleaned: 0,
-		History:      []string{},
-	}
+	cfg := defaultConfig()
 
-	file, err := os.Open(configFileName)
+	data, err := os.ReadFile(configFileName)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return cfg, nil // Return default if file doesn't exist
 		}
 		return nil, err
 	}
-	defer file.Close()
+	setConfigData(data)
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(cfg)
+	err = json.Unmarshal(data, cfg)
 	if err != nil {
 		return cfg, nil // Return default/partial on error, or handle differently
 	}
@@ -45,14 +103,102 @@ func LoadConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// ReloadConfig reads a config file edited while PureLink runs. It returns
+// nil if the file is unchanged since PureLink last read or wrote it, and an
+// error, leaving the live settings alone, if the edit is invalid.
+func ReloadConfig() (*Config, error) {
+	data, err := os.ReadFile(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	configDataMutex.Lock()
+	unchanged := bytes.Equal(data, configData)
+	configDataMutex.Unlock()
+	if unchanged {
+		return nil, nil
+	}
+
+	cfg := defaultConfig()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	setConfigData(data)
+	return cfg, nil
+}
+
+func setConfigData(data []byte) {
+	configDataMutex.Lock()
+	configData = data
+	configDataMutex.Unlock()
+}
+
+// validate rejects settings the tray could never have written.
+func (c *Config) validate() error {
+	if !slices.Contains([]string{IDNKeep, IDNUnicode, IDNPunycode}, c.IDNPolicy) {
+		return fmt.Errorf("unknown idn_policy %q", c.IDNPolicy)
+	}
+	if !slices.Contains([]string{CredentialStrip, CredentialKeep, CredentialWarn}, c.CredentialPolicy) {
+		return fmt.Errorf("unknown credential_policy %q", c.CredentialPolicy)
+	}
+	for _, category := range c.DisabledCategories {
+		if !slices.Contains(RuleCategories, category) {
+			return fmt.Errorf("unknown category %q in disabled_categories", category)
+		}
+	}
+	for _, key := range c.TrustedKeys {
+		if _, err := parsePublicKey(key); err != nil {
+			return fmt.Errorf("trusted_keys: %v", err)
+		}
+	}
+	if _, err := parseNetworks(c.AllowedNetworks); err != nil {
+		return fmt.Errorf("allowed_networks: %v", err)
+	}
+	for _, sub := range c.Subscriptions {
+		if sub.Name == "" || sub.URL == "" {
+			return fmt.Errorf("every subscription needs a name and a url")
+		}
+	}
+	return nil
+}
+
+// CleanOptions returns the cleaning settings selected in the tray.
+func (c *Config) CleanOptions() CleanOptions {
+	// Invalid entries are rejected when the config is reloaded; one that
+	// predates this check allows nothing
+	allowed, _ := parseNetworks(c.AllowedNetworks)
+	return CleanOptions{
+		Unshorten:          c.Unshorten,
+		DetectShortLinks:   c.DetectShortLinks,
+		WSLMode:            c.WSLMode,
+		DirectLink:         c.DirectLink,
+		EmbeddedLinks:      c.CleanInText,
+		NeverClean:         append([]string(nil), c.NeverClean...),
+		StripTextFragments: c.StripTextFragments,
+		DeAMP:              c.DeAMP,
+		IDNPolicy:          c.IDNPolicy,
+		CredentialPolicy:   c.CredentialPolicy,
+		DisabledCategories: append([]string(nil), c.DisabledCategories...),
+		AllowedNetworks:    allowed,
+	}
+}
+
 func SaveConfig(cfg *Config) error {
-	file, err := os.Create(configFileName)
+	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	data = append(data, '\n')
+	setConfigData(data)
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(cfg)
+	// Write through a temporary file so the file watcher never reads a partial config
+	tmp := configFileName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFileName)
 }
@@ -2,76 +2,600 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 // RuleConfig defines the structure of the rules.json file
 type RuleConfig struct {
-	Blocklist []string `json:"blocklist"`
+	// Version increases with every published rule set; updates never go back to an older one.
+	Version   int64        `json:"version,omitempty"`
+	Blocklist []string     `json:"blocklist"`
+	Rules     []ScopedRule `json:"rules,omitempty"`
+	// Redirects lists wrapper URLs to unwrap; the built-in list is used when absent.
+	Redirects []RedirectRule `json:"redirects,omitempty"`
+	// Exceptions exempt hosts, paths or single parameters from cleaning.
+	Exceptions []ExceptionRule `json:"exceptions,omitempty"`
+	// PathRules rewrite tracking segments out of the path; the built-in list is used when absent.
+	PathRules []PathRule `json:"path_rules,omitempty"`
+	// SecretParams name query parameters that carry credentials; the built-in list is used when absent.
+	SecretParams []string `json:"secret_params,omitempty"`
+	// Shorteners are hosts whose links are resolved by "Unshorten Links"; the built-in list is used when absent.
+	Shorteners []string `json:"shorteners,omitempty"`
+	// IgnoreCase makes every parameter pattern match regardless of case.
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// Tests are checked against the whole rule set before an update is accepted.
+	Tests []RuleTest `json:"tests,omitempty"`
+}
+
+// ScopedRule removes Params only on URLs it applies to. A rule applies when
+// the host matches one of Hosts (the domain itself and all of its subdomains)
+// and, if set, the full URL matches URLPattern; a rule with neither applies
+// everywhere. URLs matching any of the Exceptions are left alone.
+type ScopedRule struct {
+	Name string `json:"name,omitempty"`
+	// Category is one of the Category* constants and defaults to tracking.
+	Category   string   `json:"category,omitempty"`
+	Hosts      []string `json:"hosts,omitempty"`
+	URLPattern string   `json:"url_pattern,omitempty"`
+	Params     []string `json:"params"`
+	// RawRules are regular expressions whose matches are cut out of the URL.
+	RawRules   []string `json:"raw_rules,omitempty"`
+	Exceptions []string `json:"exceptions,omitempty"`
+	// Redirections extract an embedded target URL from capture group 1.
+	Redirections []string `json:"redirections,omitempty"`
+	// Tests are example links showing what the rule is meant to do.
+	Tests []RuleTest `json:"tests,omitempty"`
+}
+
+// paramMatcher is a compiled blocklist entry. Entries are exact names,
+// globs such as "utm_*", or anchored regular expressions prefixed with "re:".
+type paramMatcher struct {
+	entry      string
+	re         *regexp.Regexp
+	ignoreCase bool
+}
+
+// compiledRule is a ScopedRule with its patterns compiled. The global
+// blocklist is compiled into a rule without any scope.
+type compiledRule struct {
+	name string
+	// user marks rules from user_rules.json, which subscriptions cannot override.
+	user         bool
+	category     string
+	hosts        []string
+	urlPattern   *regexp.Regexp
+	params       []paramMatcher
+	rawRules     []*regexp.Regexp
+	exceptions   []*regexp.Regexp
+	redirections []*regexp.Regexp
 }
 
 var (
-	// ActiveBlocklist holds the currently loaded tracking parameters
-	ActiveBlocklist []string
-	// BlocklistLock ensures safe concurrent access to ActiveBlocklist
+	// BlocklistLock ensures safe concurrent access to activeRules
 	BlocklistLock sync.RWMutex
+
+	// activeRules is the compiled form of the active rules, rebuilt by LoadRules.
+	activeRules *ruleSet
+	// loadedRules maps each subscription's rule file to the ruleHash of the
+	// content LoadRules last read from it.
+	loadedRules map[string]string
 )
 
+// ruleSet is a compiled rule file. A set is never modified once built, so a
+// cleaning pass keeps using the one it started with while rules are reloaded.
+type ruleSet struct {
+	rules      []compiledRule
+	redirects  []RedirectRule
+	exceptions []compiledException
+	pathRules  []compiledPathRule
+	secrets    []paramMatcher
+	shorteners []string
+}
+
+// userRules returns the part of rs that comes from user_rules.json.
+func (rs *ruleSet) userRules() *ruleSet {
+	user := &ruleSet{}
+	for _, r := range rs.rules {
+		if r.user {
+			user.rules = append(user.rules, r)
+		}
+	}
+	for _, e := range rs.exceptions {
+		if e.user {
+			user.exceptions = append(user.exceptions, e)
+		}
+	}
+	return user
+}
+
+// loadedHash returns the ruleHash of the rules last loaded from file.
+func loadedHash(file string) string {
+	BlocklistLock.RLock()
+	defer BlocklistLock.RUnlock()
+	return loadedRules[file]
+}
+
+// currentRules returns the active rule set.
+func currentRules() *ruleSet {
+	BlocklistLock.RLock()
+	defer BlocklistLock.RUnlock()
+	if activeRules == nil {
+		return &ruleSet{}
+	}
+	return activeRules
+}
+
 const rulesFileName = "rules.json"
 
-// LoadRules reads the rules.json file. If it doesn't exist, it creates it with defaults.
-func LoadRules() error {
+// defaultRuleConfig returns the built-in rules used when rules.json is missing or broken.
+func defaultRuleConfig() *RuleConfig {
+	return &RuleConfig{
+		Blocklist: []string{
+			"fbclid", "gclid", "gclsrc", "dclid", "msclkid", "mc_eid",
+			"yclid", "vero_conv", "vero_id", "wickedid",
+		},
+		Rules: []ScopedRule{
+			{Name: "analytics", Category: CategoryAnalytics, Params: []string{"utm_*", "_ga"}},
+			{Name: "share markers", Category: CategorySocialShare, Params: []string{"share_id", "igshid"}},
+			{Category: CategorySocialShare, Hosts: []string{"youtube.com", "youtu.be", "spotify.com"}, Params: []string{"si"}},
+			{Category: CategorySocialShare, Hosts: []string{"twitter.com", "x.com"}, Params: []string{"ref_src", "ref_url"}},
+			{Category: CategoryAffiliate, Hosts: []string{"amazon.*"}, Params: []string{"tag", "linkCode", "linkId", "ascsubtag", "ref", "ref_"}},
+			{Category: CategoryAffiliate, Hosts: []string{"producthunt.com"}, Params: []string{"ref"}},
+			{Hosts: []string{"medium.com"}, Params: []string{"source"}},
+		},
+		Redirects:    defaultRedirects,
+		PathRules:    defaultPathRules,
+		SecretParams: defaultSecretParams,
+		Shorteners:   defaultShorteners,
+		IgnoreCase:   true,
+	}
+}
+
+// legacyBlocklist is the flat rules.json shipped before scoped rules, which
+// stripped si and ref on every host.
+var legacyBlocklist = []string{
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content",
+	"fbclid", "si", "ref", "gclid", "gclsrc", "dclid", "msclkid", "mc_eid",
+	"_ga", "yclid", "vero_conv", "vero_id", "wickedid", "share_id", "igshid",
+}
+
+// migrateLegacyRules turns a rules.json that is still in the legacy format,
+// a bare blocklist containing every legacyBlocklist entry, into the built-in
+// rules. Parameters added to it by hand stay in the global blocklist.
+func migrateLegacyRules(config *RuleConfig) (*RuleConfig, bool) {
+	legacy := config.Version == 0 && config.Rules == nil && config.Redirects == nil &&
+		config.Exceptions == nil && config.PathRules == nil && config.SecretParams == nil &&
+		config.Shorteners == nil && config.Tests == nil && !config.IgnoreCase
+	for _, p := range legacyBlocklist {
+		legacy = legacy && slices.Contains(config.Blocklist, p)
+	}
+	if !legacy {
+		return config, false
+	}
+	migrated := defaultRuleConfig()
+	for _, p := range config.Blocklist {
+		if !slices.Contains(legacyBlocklist, p) && !slices.Contains(migrated.Blocklist, p) {
+			migrated.Blocklist = append(migrated.Blocklist, p)
+		}
+	}
+	return migrated, true
+}
+
+// LoadRules merges user_rules.json and every enabled subscription into the
+// active rules. User rules take precedence: their exceptions and kept
+// parameters override every subscription, while a subscription's exceptions
+// never exempt links from the user's own rules. Subscriptions follow in list
+// order. A broken source is skipped and
+// reported; the official rules fall back to the built-in defaults instead.
+// Rule files may be in PureLink's own format or in the ClearURLs data.min.json format.
+func LoadRules(subs []Subscription) error {
 	BlocklistLock.Lock()
 	defer BlocklistLock.Unlock()
 
-	// Default rules
-	defaultRules := []string{
-		"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content",
-		"fbclid", "si", "ref", "gclid", "gclsrc", "dclid",
-		"msclkid", "mc_eid", "_ga", "yclid", "vero_conv", "vero_id", "wickedid",
-		"share_id", "igshid",
-	}
-
-	// Check if file exists
-	if _, err := os.Stat(rulesFileName); os.IsNotExist(err) {
-		// Create default file
-		initialConfig := RuleConfig{Blocklist: defaultRules}
-		if err := saveRulesToFile(&initialConfig); err != nil {
-			// If we can't save, just load defaults into memory
-			ActiveBlocklist = defaultRules
-			return err
+	var errs []error
+	var sources []ruleSource
+	addSource := func(name string, config *RuleConfig, user, fallback bool) {
+		src := ruleSource{name: name, config: config, user: user}
+		if _, err := compileRuleSet(src); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			if !fallback {
+				return
+			}
+			src.config = defaultRuleConfig()
 		}
+		sources = append(sources, src)
 	}
 
-	// Read file
-	file, err := os.Open(rulesFileName)
+	user, err := loadUserRules()
 	if err != nil {
-		// Fallback to defaults if read fails
-		ActiveBlocklist = defaultRules
-		return err
+		errs = append(errs, fmt.Errorf("%s: %v", userRulesFileName, err))
+	}
+	if user != nil {
+		addSource("user", user, true, false)
+	}
+	loadedRules = make(map[string]string)
+	for _, sub := range subs {
+		if !sub.Enabled {
+			continue
+		}
+		config, err := sub.load()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", sub.Name, err))
+		}
+		if config != nil {
+			loadedRules[sub.file()] = ruleHash(config)
+			addSource(sub.sourceName(), config, false, sub.official())
+		}
+	}
+
+	applyRuleSources(sources)
+	return errors.Join(errs...)
+}
+
+// ReloadRules is LoadRules for rule files edited while PureLink runs. Every
+// source is checked, including its self-tests, before anything is swapped;
+// if one is broken the current rules stay active instead of falling back to
+// the built-in defaults.
+func ReloadRules(subs []Subscription) error {
+	files := []string{userRulesFileName}
+	for _, sub := range subs {
+		if sub.Enabled {
+			files = append(files, sub.file())
+		}
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		config, err := parseRules(data)
+		if err == nil {
+			err = testRules(config)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return LoadRules(subs)
+}
+
+// parseRules decodes a rule file, translating the ClearURLs format when detected.
+func parseRules(data []byte) (*RuleConfig, error) {
+	var probe struct {
+		Providers json.RawMessage `json:"providers"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Providers != nil {
+		var clear clearURLsData
+		if err := json.Unmarshal(data, &clear); err != nil {
+			return nil, err
+		}
+		return clear.toRuleConfig(), nil
 	}
-	defer file.Close()
 
 	var config RuleConfig
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		ActiveBlocklist = defaultRules
-		return err
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
 	}
+	return &config, nil
+}
 
-	ActiveBlocklist = config.Blocklist
-	return nil
+// ruleSource is one rule file contributing to the active rules. The
+// official rules have no name, so their blocklist is simply "blocklist".
+type ruleSource struct {
+	name   string
+	config *RuleConfig
+	// user is set for user_rules.json, whose rules take precedence.
+	user bool
 }
 
-func saveRulesToFile(config *RuleConfig) error {
-	file, err := os.Create(rulesFileName)
+// applyRuleSources makes the merged sources the active rule set. Every
+// source must already compile on its own.
+// Callers must hold BlocklistLock for writing.
+func applyRuleSources(sources []ruleSource) {
+	rs, err := compileRuleSet(sources...)
 	if err != nil {
-		return err
+		rs, _ = compileRuleSet(ruleSource{config: defaultRuleConfig()})
+	}
+	activeRules = rs
+}
+
+// compileRuleSet compiles and merges sources without activating them. Rules
+// keep the order of sources; the built-in redirects, path rules, secret
+// params and shorteners are used when no source defines its own.
+func compileRuleSet(sources ...ruleSource) (*ruleSet, error) {
+	rs := &ruleSet{}
+	var redirects, pathRules, secretParams, shorteners bool
+	for _, src := range sources {
+		config := src.config
+		name := "blocklist"
+		if src.name != "" {
+			name = src.name + " blocklist"
+		}
+
+		global, err := compileParams(config.Blocklist, config.IgnoreCase)
+		if err != nil {
+			return nil, err
+		}
+		rs.rules = append(rs.rules, compiledRule{name: name, user: src.user, category: CategoryTracking, params: global})
+		for _, rule := range config.Rules {
+			c, err := compileRule(rule, config.IgnoreCase)
+			if err != nil {
+				return nil, err
+			}
+			c.user = src.user
+			rs.rules = append(rs.rules, c)
+		}
+		exceptions, err := compileExceptions(config.Exceptions, config.IgnoreCase)
+		if err != nil {
+			return nil, err
+		}
+		for i := range exceptions {
+			exceptions[i].user = src.user
+		}
+		rs.exceptions = append(rs.exceptions, exceptions...)
+
+		if config.PathRules != nil {
+			compiledPaths, err := compilePathRules(config.PathRules)
+			if err != nil {
+				return nil, err
+			}
+			rs.pathRules = append(rs.pathRules, compiledPaths...)
+			pathRules = true
+		}
+		if config.SecretParams != nil {
+			secrets, err := compileParams(config.SecretParams, true)
+			if err != nil {
+				return nil, err
+			}
+			rs.secrets = append(rs.secrets, secrets...)
+			secretParams = true
+		}
+		if config.Redirects != nil {
+			rs.redirects = append(rs.redirects, config.Redirects...)
+			redirects = true
+		}
+		if config.Shorteners != nil {
+			hosts, err := compileShorteners(config.Shorteners)
+			if err != nil {
+				return nil, err
+			}
+			rs.shorteners = append(rs.shorteners, hosts...)
+			shorteners = true
+		}
+	}
+
+	if !pathRules {
+		rs.pathRules, _ = compilePathRules(defaultPathRules)
 	}
-	defer file.Close()
+	if !secretParams {
+		rs.secrets, _ = compileParams(defaultSecretParams, true)
+	}
+	if !redirects {
+		rs.redirects = defaultRedirects
+	}
+	if !shorteners {
+		rs.shorteners = defaultShorteners
+	}
+	return rs, nil
+}
+
+func compileRule(rule ScopedRule, ignoreCase bool) (compiledRule, error) {
+	c := compiledRule{name: rule.Name, hosts: rule.Hosts}
+	if c.name == "" {
+		c.name = strings.Join(rule.Hosts, ", ")
+	}
+
+	var err error
+	if c.category, err = validCategory(rule.Category); err != nil {
+		return c, fmt.Errorf("rule %q: %v", c.name, err)
+	}
+	if rule.URLPattern != "" {
+		if c.urlPattern, err = regexp.Compile(rule.URLPattern); err != nil {
+			return c, fmt.Errorf("rule %q: invalid url_pattern: %v", c.name, err)
+		}
+	}
+	if c.params, err = compileParams(rule.Params, ignoreCase); err != nil {
+		return c, fmt.Errorf("rule %q: %v", c.name, err)
+	}
+	if c.rawRules, err = compileRegexps(rule.RawRules); err != nil {
+		return c, fmt.Errorf("rule %q: invalid raw rule: %v", c.name, err)
+	}
+	if c.exceptions, err = compileRegexps(rule.Exceptions); err != nil {
+		return c, fmt.Errorf("rule %q: invalid exception: %v", c.name, err)
+	}
+	if c.redirections, err = compileRegexps(rule.Redirections); err != nil {
+		return c, fmt.Errorf("rule %q: invalid redirection: %v", c.name, err)
+	}
+	return c, nil
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(config)
+func compileParams(entries []string, ignoreCase bool) ([]paramMatcher, error) {
+	matchers := make([]paramMatcher, 0, len(entries))
+	for _, entry := range entries {
+		m, err := compileParam(entry, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func compileParam(entry string, ignoreCase bool) (paramMatcher, error) {
+	m := paramMatcher{entry: entry, ignoreCase: ignoreCase}
+
+	var expr string
+	if pattern, ok := strings.CutPrefix(entry, "re:"); ok {
+		expr = pattern
+	} else if strings.ContainsAny(entry, "*?") {
+		expr = regexp.QuoteMeta(entry)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+	} else {
+		return m, nil
+	}
+
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return m, fmt.Errorf("invalid pattern %q: %v", entry, err)
+	}
+	m.re = re
+	return m, nil
+}
+
+func (m paramMatcher) match(name string) bool {
+	if m.re != nil {
+		return m.re.MatchString(name)
+	}
+	if m.ignoreCase {
+		return strings.EqualFold(m.entry, name)
+	}
+	return m.entry == name
+}
+
+// appliesTo reports whether the rule is in scope for rawURL on host.
+func (r *compiledRule) appliesTo(rawURL, host string) bool {
+	if len(r.hosts) > 0 && !anyHostMatches(host, r.hosts) {
+		return false
+	}
+	if r.urlPattern != nil && !r.urlPattern.MatchString(rawURL) {
+		return false
+	}
+	for _, re := range r.exceptions {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	return true
+}
+
+// rulesFor returns the rules that apply to rawURL, skipping those in a
+// disabled category.
+func (rs *ruleSet) rulesFor(rawURL, host string, disabled []string) []*compiledRule {
+	var rules []*compiledRule
+	for i := range rs.rules {
+		if rs.rules[i].appliesTo(rawURL, host) {
+			rules = append(rules, &rs.rules[i])
+		}
+	}
+	return withoutCategories(rules, disabled)
+}
+
+// matchParam returns the first of rules blocking the query parameter name
+// and describes the entry that matched, e.g. "utm_* in blocklist". User
+// rules come first, so a parameter the user blocks is reported as theirs.
+func matchParam(rules []*compiledRule, name string) (*compiledRule, string) {
+	for _, rule := range rules {
+		for _, m := range rule.params {
+			if m.match(name) {
+				return rule, m.entry + " in " + rule.name
+			}
+		}
+	}
+	return nil, ""
+}
+
+// rewriteURL cuts raw rule matches out of rawURL, recording them in res.
+func (rs *ruleSet) rewriteURL(rawURL string, disabled []string, res *CleanResult) string {
+	for _, rule := range rs.rulesFor(rawURL, hostOf(rawURL), disabled) {
+		for _, re := range rule.rawRules {
+			for _, match := range re.FindAllString(rawURL, -1) {
+				res.add(ActionRawRule, match, rule.name)
+			}
+			rawURL = re.ReplaceAllString(rawURL, "")
+		}
+	}
+	return rawURL
+}
+
+func (r *compiledRule) redirectTarget(rawURL string) (string, bool) {
+	for _, re := range r.redirections {
+		m := re.FindStringSubmatch(rawURL)
+		if len(m) < 2 {
+			continue
+		}
+		if target, ok := decodeTarget(m[1]); ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func anyHostMatches(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if hostMatches(host, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatches reports whether host equals pattern or is a subdomain of it.
+// A trailing ".*" stands for exactly one ICANN public suffix, so "amazon.*"
+// matches amazon.de and smile.amazon.co.uk but not amazon.evil.com.
+func hostMatches(host, pattern string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	pattern = strings.ToLower(pattern)
+	if base, ok := strings.CutSuffix(pattern, ".*"); ok {
+		i := strings.LastIndex("."+host, "."+base+".")
+		if i < 0 {
+			return false
+		}
+		rest := host[i+len(base)+1:]
+		suffix, icann := publicsuffix.PublicSuffix(rest)
+		return icann && suffix == rest
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// saveRulesToFile writes config to name through a temporary file, so a
+// crash or a concurrent reader never sees a half-written rule file.
+func saveRulesToFile(name string, config *RuleConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
 }
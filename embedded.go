@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// embeddedURLPattern finds http(s) links in free-form text. Square brackets
+// and angle brackets end a match so Markdown links and <autolinks> keep
+// their syntax; trailing punctuation is trimmed by trimURLSpan.
+var embeddedURLPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'\x60\[\]]+`)
+
+// isSingleURL reports whether s is one link and nothing else.
+func isSingleURL(s string) bool {
+	return hasHTTPPrefix(s) && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// hasHTTPPrefix reports whether s starts with "http", ignoring case.
+func hasHTTPPrefix(s string) bool {
+	return len(s) >= 4 && strings.EqualFold(s[:4], "http")
+}
+
+// cleanEmbeddedURLs cleans every URL inside text and rewrites only those
+// spans, leaving the surrounding text byte-for-byte intact.
+func cleanEmbeddedURLs(ctx context.Context, text string, opts CleanOptions, res *CleanResult) string {
+	matches := embeddedURLPattern.FindAllStringIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[0]+len(trimURLSpan(text[m[0]:m[1]]))
+		cleaned := cleanURL(ctx, text[start:end], opts, res)
+		res.warnLookalike(hostWarnings(hostOf(cleaned))...)
+		b.WriteString(text[last:start])
+		b.WriteString(cleaned)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// trimURLSpan drops sentence punctuation and unbalanced closing brackets
+// from the end of a candidate link, so "(see https://x.com/a)." yields
+// "https://x.com/a" while "https://x.com/Foo_(bar)" is kept whole.
+func trimURLSpan(span string) string {
+	for len(span) > 0 {
+		last := span[len(span)-1]
+		switch {
+		case strings.IndexByte(".,;:!?*_~", last) >= 0:
+			span = span[:len(span)-1]
+		case last == ')' && strings.Count(span, ")") > strings.Count(span, "("):
+			span = span[:len(span)-1]
+		case last == '}' && strings.Count(span, "}") > strings.Count(span, "{"):
+			span = span[:len(span)-1]
+		default:
+			return span
+		}
+	}
+	return span
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ExceptionRule exempts links from cleaning. Without Params every link on
+// Hosts (optionally limited to PathPrefix) is left untouched; with Params
+// only those parameters are kept while the rest of the link is still cleaned.
+type ExceptionRule struct {
+	Hosts      []string `json:"hosts"`
+	PathPrefix string   `json:"path_prefix,omitempty"`
+	Params     []string `json:"params,omitempty"`
+}
+
+// compiledException is an ExceptionRule with its parameter patterns compiled.
+type compiledException struct {
+	hosts      []string
+	pathPrefix string
+	params     []paramMatcher
+	// user marks exceptions from user_rules.json.
+	user bool
+}
+
+func compileExceptions(rules []ExceptionRule, ignoreCase bool) ([]compiledException, error) {
+	compiled := make([]compiledException, 0, len(rules))
+	for _, rule := range rules {
+		params, err := compileParams(rule.Params, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledException{
+			hosts:      rule.Hosts,
+			pathPrefix: rule.PathPrefix,
+			params:     params,
+		})
+	}
+	return compiled, nil
+}
+
+func (e *compiledException) appliesTo(u *url.URL) bool {
+	return anyHostMatches(u.Hostname(), e.hosts) && strings.HasPrefix(u.Path, e.pathPrefix)
+}
+
+// exemption reports whether rawURL must not be cleaned at all, because of
+// an exception in a rule file or a host the user chose to never clean.
+// byUser is false when only a subscription's exceptions apply; those do not
+// exempt the link from the user's own rules.
+func (rs *ruleSet) exemption(rawURL string, opts CleanOptions) (exempt, byUser bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, false
+	}
+	if anyHostMatches(u.Hostname(), opts.NeverClean) {
+		return true, true
+	}
+	for i := range rs.exceptions {
+		e := &rs.exceptions[i]
+		if len(e.params) == 0 && e.appliesTo(u) {
+			exempt = true
+			byUser = byUser || e.user
+		}
+	}
+	return exempt, byUser
+}
+
+// keptParam reports whether an exception protects param on u and returns the
+// matching entry. With userOnly, as for parameters the user's own rules
+// block, subscriptions' exceptions are ignored.
+func (rs *ruleSet) keptParam(u *url.URL, param string, userOnly bool) (string, bool) {
+	for i := range rs.exceptions {
+		e := &rs.exceptions[i]
+		if !e.appliesTo(u) || userOnly && !e.user {
+			continue
+		}
+		for _, m := range e.params {
+			if m.match(param) {
+				return m.entry, true
+			}
+		}
+	}
+	return "", false
+}
+
+// neverCleanHost returns the host to exempt for rawURL, without a leading
+// "www." so the exemption also covers the bare domain and other subdomains.
+func neverCleanHost(rawURL string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."), true
+}
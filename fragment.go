@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// textDirective starts the Scroll-To-Text part of a fragment, as in
+// "#section:~:text=quoted%20words".
+const textDirective = ":~:"
+
+// cleanFragment applies the blocklist to query-like fragments such as
+// "#utm_source=x" or SPA routes like "#/page?fbclid=1", and drops text
+// highlights when opts.StripTextFragments is set. Plain anchors ("#install")
+// are never touched.
+func (rs *ruleSet) cleanFragment(u *url.URL, rules []*compiledRule, opts CleanOptions, res *CleanResult) {
+	if u.EscapedFragment() == "" {
+		return
+	}
+
+	route, q, directive, hasQuery := splitFragment(u.EscapedFragment())
+	if directive != "" && opts.StripTextFragments {
+		res.add(ActionTextFragment, directive, "")
+		directive = ""
+	}
+	if hasQuery {
+		q.removeIf(func(param string) bool {
+			return rs.stripParam(u, rules, param, res)
+		})
+	}
+	setEscapedFragment(u, joinFragment(route, q, directive))
+}
+
+// splitFragment separates an escaped fragment into the route before a
+// query-like part, that part's parameters and a trailing text directive.
+// hasQuery is false for plain anchors such as "install".
+func splitFragment(frag string) (route string, q rawQuery, directive string, hasQuery bool) {
+	if i := strings.Index(frag, textDirective); i >= 0 {
+		frag, directive = frag[:i], frag[i:]
+	}
+	route, query, hasQuery := strings.Cut(frag, "?")
+	if !hasQuery && strings.Contains(frag, "=") && !strings.Contains(frag, "/") {
+		route, query, hasQuery = "", frag, true
+	}
+	if hasQuery {
+		q = parseRawQuery(query)
+	}
+	return route, q, directive, hasQuery
+}
+
+// joinFragment reverses splitFragment.
+func joinFragment(route string, q rawQuery, directive string) string {
+	frag := route
+	if len(q) > 0 {
+		if route != "" {
+			frag += "?"
+		}
+		frag += q.String()
+	}
+	return frag + directive
+}
+
+func setEscapedFragment(u *url.URL, frag string) {
+	if frag == u.EscapedFragment() {
+		return
+	}
+	unescaped, err := url.PathUnescape(frag)
+	if err != nil {
+		return
+	}
+	u.Fragment, u.RawFragment = unescaped, frag
+}
@@ -7,7 +7,8 @@ require (
 	github.com/getlantern/systray v1.2.2
 	github.com/gofrs/flock v0.13.0
 	github.com/sqweek/dialog v0.0.0-20240226140203-065105509627
-	golang.org/x/sys v0.37.0
+	golang.org/x/net v0.47.0
+	golang.org/x/sys v0.38.0
 )
 
 require (
@@ -20,4 +21,5 @@ require (
 	github.com/getlantern/ops v0.0.0-20190325191751-d70cb0d6f85f // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
+	golang.org/x/text v0.31.0 // indirect
 )
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed confusables.txt
+var confusablesData string
+
+var (
+	confusablesOnce sync.Once
+	confusables     map[rune]string
+)
+
+// letterScripts are the scripts a hostname letter is classified into.
+// Anything else counts as "Other".
+var letterScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+	{"Armenian", unicode.Armenian},
+	{"Cherokee", unicode.Cherokee},
+	{"Georgian", unicode.Georgian},
+	{"Hebrew", unicode.Hebrew},
+	{"Arabic", unicode.Arabic},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+}
+
+// Script mixes that are normal in real-world domain names.
+var allowedScriptMixes = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// loadConfusables parses the embedded UTS #39 subset on first use.
+func loadConfusables() map[rune]string {
+	confusablesOnce.Do(func() {
+		confusables = make(map[rune]string)
+		scanner := bufio.NewScanner(strings.NewReader(confusablesData))
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Split(line, ";")
+			if len(fields) < 2 {
+				continue
+			}
+			src, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 16, 32)
+			if err != nil {
+				continue
+			}
+			var target strings.Builder
+			for _, cp := range strings.Fields(fields[1]) {
+				r, err := strconv.ParseUint(cp, 16, 32)
+				if err == nil {
+					target.WriteRune(rune(r))
+				}
+			}
+			confusables[rune(src)] = target.String()
+		}
+	})
+	return confusables
+}
+
+// skeleton maps every confusable character of s to its Latin lookalike.
+func skeleton(s string) string {
+	table := loadConfusables()
+	var b strings.Builder
+	for _, r := range s {
+		if target, ok := table[r]; ok {
+			b.WriteString(target)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return strings.ToLower(b.String())
+}
+
+func scriptOf(r rune) string {
+	for _, s := range letterScripts {
+		if unicode.Is(s.table, r) {
+			return s.name
+		}
+	}
+	return "Other"
+}
+
+// labelScripts returns the sorted scripts used by the letters of label.
+func labelScripts(label string) []string {
+	seen := map[string]bool{}
+	for _, r := range label {
+		if unicode.IsLetter(r) {
+			seen[scriptOf(r)] = true
+		}
+	}
+	scripts := make([]string, 0, len(seen))
+	for s := range seen {
+		scripts = append(scripts, s)
+	}
+	sort.Strings(scripts)
+	return scripts
+}
+
+func isAllowedMix(scripts []string) bool {
+	for _, allowed := range allowedScriptMixes {
+		ok := true
+		for _, s := range scripts {
+			if !slices.Contains(allowed, s) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostWarnings flags hostnames that mix scripts within a label, or that are
+// written in a non-Latin script but read as a Latin name (аpple.com, раураl.com).
+// Punycode labels are decoded first, so xn-- lookalikes are caught too.
+func hostWarnings(host string) []string {
+	if host == "" || isASCII(host) && !strings.Contains(strings.ToLower(host), acePrefix) {
+		return nil
+	}
+	display, err := hostToUnicode(strings.ToLower(host))
+	if err != nil {
+		return []string{fmt.Sprintf("%s has a malformed punycode label", host)}
+	}
+
+	var warnings []string
+	lookalike := false
+	for _, label := range strings.Split(display, ".") {
+		if isASCII(label) {
+			continue
+		}
+		scripts := labelScripts(label)
+		if len(scripts) > 1 && !isAllowedMix(scripts) {
+			warnings = append(warnings, fmt.Sprintf("%s mixes %s letters", display, strings.Join(scripts, " and ")))
+		}
+		if isASCII(skeleton(label)) {
+			lookalike = true
+		}
+	}
+	if lookalike {
+		warnings = append(warnings, fmt.Sprintf("%s looks like %s but uses different characters", display, skeleton(display)))
+	}
+	return warnings
+}
+
+var (
+	markdownLinkPattern = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	htmlLinkPattern     = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	htmlTagPattern      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// linkTextWarnings compares the visible text of Markdown and HTML links with
+// their targets and flags text that shows one domain but points to another.
+func linkTextWarnings(text string) []string {
+	var warnings []string
+	for _, m := range markdownLinkPattern.FindAllStringSubmatch(text, -1) {
+		if w, ok := linkTextMismatch(m[1], m[2]); ok {
+			warnings = append(warnings, w)
+		}
+	}
+	for _, m := range htmlLinkPattern.FindAllStringSubmatch(text, -1) {
+		visible := htmlTagPattern.ReplaceAllString(m[2], "")
+		if w, ok := linkTextMismatch(visible, m[1]); ok {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// linkTextMismatch reports when visible looks like a link or domain whose
+// host differs from the host of href.
+func linkTextMismatch(visible, href string) (string, bool) {
+	visible = strings.TrimSpace(visible)
+	if visible == "" || strings.ContainsAny(visible, " \t\n") || !strings.Contains(visible, ".") {
+		return "", false
+	}
+	shown := visible
+	if !strings.Contains(shown, "://") {
+		shown = "https://" + shown
+	}
+	su, err := url.Parse(shown)
+	if err != nil || !looksLikeDomain(su.Hostname()) {
+		return "", false
+	}
+	tu, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || tu.Hostname() == "" {
+		return "", false
+	}
+
+	shownHost := strings.TrimPrefix(strings.ToLower(su.Hostname()), "www.")
+	targetHost := strings.TrimPrefix(strings.ToLower(tu.Hostname()), "www.")
+	if hostMatches(targetHost, shownHost) || hostMatches(shownHost, targetHost) {
+		return "", false
+	}
+	return fmt.Sprintf("link text shows %s but points to %s", su.Hostname(), tu.Hostname()), true
+}
+
+// looksLikeDomain reports whether host has at least two labels and an
+// alphabetic top-level domain, so "v1.2" or "e.g" are not read as links.
+func looksLikeDomain(host string) bool {
+	i := strings.LastIndexByte(host, '.')
+	if i <= 0 || len(host)-i-1 < 2 {
+		return false
+	}
+	for _, r := range host[i+1:] {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
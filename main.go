@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	_ "embed" // New import, used for //go:embed directive
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -58,7 +62,7 @@ func onReady() {
 
 		// Load Rules
 
-		if err := LoadRules(); err != nil {
+		if err := LoadRules(cfg.Subscriptions); err != nil {
 
 			fmt.Println("Error loading rules:", err)
 
@@ -83,6 +87,30 @@ func onReady() {
 		systray.AddMenuItem("Status: Active", "Protection is enabled").Disable()
 
 		mCounter := systray.AddMenuItem(fmt.Sprintf("Cleaned: %d Links", cfg.TotalCleaned), "Total items processed")
+		mWarning := systray.AddMenuItem("⚠ Suspicious Link", "")
+		mWarning.Hide()
+		var lastWarning, lastWarningTitle string // Guarded by cfgMutex
+
+		// Helper to surface warnings: lookalike domains and misleading link text
+		// as suspicious, credentials and unfollowed short links as a notice
+		showWarnings := func(result CleanResult) {
+			details := result.Output + "\n\n" + strings.Join(result.Warnings, "\n")
+			title, intro := "Link Warning", "Please check this link:"
+			if result.Lookalike() {
+				title, intro = "Suspicious Link", "This link may be impersonating another site:"
+			}
+			cfgMutex.Lock()
+			lastWarning, lastWarningTitle = details, title
+			cfgMutex.Unlock()
+			mWarning.SetTitle("⚠ " + title)
+			mWarning.SetTooltip(strings.Join(result.Warnings, "\n"))
+			mWarning.Show()
+			if result.Lookalike() {
+				go dialog.Message("%s\n\n%s", intro, details).Title(title).Error()
+			} else {
+				go dialog.Message("%s\n\n%s", intro, details).Title(title).Info()
+			}
+		}
 
 	
 
@@ -120,7 +148,7 @@ func onReady() {
 
 				if i < len(cfg.History) {
 
-					title := cfg.History[i]
+					title := cfg.History[i].Output
 
 					if len(title) > 50 {
 
@@ -130,7 +158,7 @@ func onReady() {
 
 					item.SetTitle(title)
 
-					item.SetTooltip(cfg.History[i])
+					item.SetTooltip(cfg.History[i].Output + "\n" + cfg.History[i].Summary())
 
 					item.Show()
 
@@ -193,17 +221,148 @@ func onReady() {
 		tEncode64 := mTools.AddSubMenuItem("Encode Base64", "Encode text to Base64")
 
 		tUUID := mTools.AddSubMenuItem("Insert UUID", "Generate and copy a new UUID")
+		tExplain := mTools.AddSubMenuItem("What Was Stripped?", "Explain what was removed from recent links")
+		tNeverClean := mTools.AddSubMenuItem("Never Clean This Domain", "Exempt the domain of the copied link from cleaning")
+		tRollback := mTools.AddSubMenuItem("Roll Back Rules", "Restore the rules from before the last update")
+		tClearCache := mTools.AddSubMenuItem("Clear Unshorten Cache", "Forget where previously unshortened links lead")
 
 	
 
 		systray.AddSeparator()
 
 		mUnshorten := systray.AddMenuItemCheckbox("Unshorten Links", "Expand short URLs (Requires Internet)", cfg.Unshorten)
+		mDetectShort := systray.AddMenuItemCheckbox("Detect Unknown Shorteners", "Also expand links that only look shortened (Requires Internet)", cfg.DetectShortLinks)
 
 		mWSL := systray.AddMenuItemCheckbox("WSL Path Mode", "Convert C:\\ to /mnt/c/ and fix slashes", cfg.WSLMode)
 
 		mCloudBoost := systray.AddMenuItemCheckbox("Direct Link", "Auto-convert Dropbox/Drive links", cfg.DirectLink)
+		mDeAMP := systray.AddMenuItemCheckbox("Remove AMP", "Replace Google AMP links with the original page", cfg.DeAMP)
+		mInText := systray.AddMenuItemCheckbox("Clean Links in Text", "Clean every link inside copied messages and documents", cfg.CleanInText)
+		mTextFrag := systray.AddMenuItemCheckbox("Strip Text Highlights", "Remove #:~:text= highlights from copied links", cfg.StripTextFragments)
+
+		mCategories := systray.AddMenuItem("Rule Categories", "Choose which kinds of parameters to remove")
+		categoryOn := func(category string) bool { return !slices.Contains(cfg.DisabledCategories, category) }
+		mCatTracking := mCategories.AddSubMenuItemCheckbox("Tracking IDs", "Remove click IDs such as fbclid and gclid", categoryOn(CategoryTracking))
+		mCatAffiliate := mCategories.AddSubMenuItemCheckbox("Affiliate Tags", "Remove referral tags such as Amazon tag= (uncheck to support creators)", categoryOn(CategoryAffiliate))
+		mCatSocial := mCategories.AddSubMenuItemCheckbox("Share Markers", "Remove app share markers such as si= and igshid=", categoryOn(CategorySocialShare))
+		mCatAnalytics := mCategories.AddSubMenuItemCheckbox("Analytics", "Remove campaign analytics such as utm_source", categoryOn(CategoryAnalytics))
+		toggleCategory := func(item *systray.MenuItem, category string) {
+			cfgMutex.Lock()
+			if i := slices.Index(cfg.DisabledCategories, category); i >= 0 {
+				cfg.DisabledCategories = slices.Delete(cfg.DisabledCategories, i, i+1)
+				item.Check()
+				NotifyBeep()
+			} else {
+				cfg.DisabledCategories = append(cfg.DisabledCategories, category)
+				item.Uncheck()
+			}
+			SaveConfig(cfg)
+			cfgMutex.Unlock()
+		}
+
+		// --- Rule Sources ---
+		mSources := systray.AddMenuItem("Rule Sources", "Rule subscriptions merged with user_rules.json")
+		var mSourceItems []*systray.MenuItem
+		for _, sub := range cfg.Subscriptions {
+			mSourceItems = append(mSourceItems, mSources.AddSubMenuItemCheckbox(sub.Name, sub.Status(), sub.Enabled))
+		}
+		// Helper to show the status of every subscription
+		updateSourcesMenu := func() {
+			cfgMutex.Lock()
+			defer cfgMutex.Unlock()
+			for i, item := range mSourceItems {
+				// Subscriptions added by editing the config appear after a restart
+				if i >= len(cfg.Subscriptions) {
+					item.Hide()
+					continue
+				}
+				sub := cfg.Subscriptions[i]
+				item.Show()
+				if sub.Enabled {
+					item.Check()
+				} else {
+					item.Uncheck()
+				}
+				item.SetTitle(fmt.Sprintf("%s (%s)", sub.Name, sub.Status()))
+				tooltip := sub.URL
+				if !sub.Changes.Empty() {
+					tooltip += "\n\nLast update:\n" + sub.Changes.Summary(10)
+				}
+				item.SetTooltip(tooltip)
+			}
+		}
+		updateSourcesMenu()
+		mAutoUpdate := mSources.AddSubMenuItemCheckbox("Update Automatically", "Check subscriptions for new rules once a day", cfg.AutoUpdate)
+
+		// Helper to store the state of each source after an update, unless the list was edited meanwhile
+		recordSubscriptions := func(subs []Subscription) {
+			cfgMutex.Lock()
+			for i := range cfg.Subscriptions {
+				if i < len(subs) && subs[i].URL == cfg.Subscriptions[i].URL {
+					cfg.Subscriptions[i].SubscriptionState = subs[i].SubscriptionState
+				}
+			}
+			SaveConfig(cfg)
+			cfgMutex.Unlock()
+			updateSourcesMenu()
+		}
+
+		// Background rule updates
+		scheduler := NewRuleScheduler(func() ([]Subscription, []string) {
+			cfgMutex.Lock()
+			defer cfgMutex.Unlock()
+			if !cfg.AutoUpdate {
+				return nil, nil
+			}
+			return slices.Clone(cfg.Subscriptions), slices.Clone(cfg.TrustedKeys)
+		}, recordSubscriptions)
+		go scheduler.Run(context.Background())
+
+		sourceClicked := make(chan int)
+		for i, item := range mSourceItems {
+			go func(idx int, m *systray.MenuItem) {
+				for range m.ClickedCh {
+					sourceClicked <- idx
+				}
+			}(i, item)
+		}
 
+		mIDN := systray.AddMenuItem("International Domains", "How to write non-Latin domain names")
+		mIDNKeep := mIDN.AddSubMenuItemCheckbox("Keep As Copied", "Leave domain names untouched", cfg.IDNPolicy == IDNKeep)
+		mIDNUnicode := mIDN.AddSubMenuItemCheckbox("Show Unicode", "Write bücher.de instead of xn--bcher-kva.de", cfg.IDNPolicy == IDNUnicode)
+		mIDNPunycode := mIDN.AddSubMenuItemCheckbox("Force Punycode", "Write xn--bcher-kva.de instead of bücher.de", cfg.IDNPolicy == IDNPunycode)
+		setIDNPolicy := func(policy string) {
+			cfgMutex.Lock()
+			cfg.IDNPolicy = policy
+			SaveConfig(cfg)
+			cfgMutex.Unlock()
+			for item, p := range map[*systray.MenuItem]string{mIDNKeep: IDNKeep, mIDNUnicode: IDNUnicode, mIDNPunycode: IDNPunycode} {
+				if p == policy {
+					item.Check()
+				} else {
+					item.Uncheck()
+				}
+			}
+			NotifyBeep()
+		}
+		mCreds := systray.AddMenuItem("Credentials in Links", "What to do with passwords and tokens inside copied links")
+		mCredsStrip := mCreds.AddSubMenuItemCheckbox("Strip", "Remove user:password@ and token parameters", cfg.CredentialPolicy == CredentialStrip)
+		mCredsKeep := mCreds.AddSubMenuItemCheckbox("Keep (Never Save)", "Leave them in the clipboard but never save them to history", cfg.CredentialPolicy == CredentialKeep)
+		mCredsWarn := mCreds.AddSubMenuItemCheckbox("Warn", "Leave them in the clipboard and show a warning", cfg.CredentialPolicy == CredentialWarn)
+		setCredentialPolicy := func(policy string) {
+			cfgMutex.Lock()
+			cfg.CredentialPolicy = policy
+			SaveConfig(cfg)
+			cfgMutex.Unlock()
+			for item, p := range map[*systray.MenuItem]string{mCredsStrip: CredentialStrip, mCredsKeep: CredentialKeep, mCredsWarn: CredentialWarn} {
+				if p == policy {
+					item.Check()
+				} else {
+					item.Uncheck()
+				}
+			}
+			NotifyBeep()
+		}
 		mStartup := systray.AddMenuItemCheckbox("Run on Startup", "Launch PureLink when system starts", false)
 
 	
@@ -236,6 +395,82 @@ func onReady() {
 
 	
 
+		// Helper to bring every checkbox in line with cfg after it was reloaded
+		syncMenu := func() {
+			cfgMutex.Lock()
+			checks := map[*systray.MenuItem]bool{
+				mUnshorten:    cfg.Unshorten,
+				mDetectShort:  cfg.DetectShortLinks,
+				mWSL:          cfg.WSLMode,
+				mCloudBoost:   cfg.DirectLink,
+				mDeAMP:        cfg.DeAMP,
+				mInText:       cfg.CleanInText,
+				mTextFrag:     cfg.StripTextFragments,
+				mCatTracking:  categoryOn(CategoryTracking),
+				mCatAffiliate: categoryOn(CategoryAffiliate),
+				mCatSocial:    categoryOn(CategorySocialShare),
+				mCatAnalytics: categoryOn(CategoryAnalytics),
+				mAutoUpdate:   cfg.AutoUpdate,
+				mIDNKeep:      cfg.IDNPolicy == IDNKeep,
+				mIDNUnicode:   cfg.IDNPolicy == IDNUnicode,
+				mIDNPunycode:  cfg.IDNPolicy == IDNPunycode,
+				mCredsStrip:   cfg.CredentialPolicy == CredentialStrip,
+				mCredsKeep:    cfg.CredentialPolicy == CredentialKeep,
+				mCredsWarn:    cfg.CredentialPolicy == CredentialWarn,
+				mSound:        cfg.Sound,
+			}
+			mCounter.SetTitle(fmt.Sprintf("Cleaned: %d Links", cfg.TotalCleaned))
+			cfgMutex.Unlock()
+			for item, on := range checks {
+				if on {
+					item.Check()
+				} else {
+					item.Uncheck()
+				}
+			}
+			updateSourcesMenu()
+			updateHistoryMenu()
+		}
+
+		// --- Hot Reload ---
+		// Pick up hand edits of the config and rule files without a restart
+		watcher := newFileWatcher(func() []string {
+			cfgMutex.Lock()
+			defer cfgMutex.Unlock()
+			files := []string{configFileName, userRulesFileName}
+			for _, sub := range cfg.Subscriptions {
+				if sub.Enabled {
+					files = append(files, sub.file())
+				}
+			}
+			return files
+		}, func(changed []string) {
+			rulesChanged := slices.ContainsFunc(changed, func(name string) bool { return name != configFileName })
+			if slices.Contains(changed, configFileName) {
+				newCfg, err := ReloadConfig()
+				if err != nil {
+					go dialog.Message("Your changes to %s were not applied:\n\n%v", configFileName, err).Title("Invalid Settings").Error()
+				} else if newCfg != nil {
+					cfgMutex.Lock()
+					*cfg = *newCfg
+					cfgMutex.Unlock()
+					syncMenu()
+					scheduler.Wake()
+					rulesChanged = true // Subscriptions may have been edited too
+				}
+			}
+			if !rulesChanged {
+				return
+			}
+			cfgMutex.Lock()
+			subs := slices.Clone(cfg.Subscriptions)
+			cfgMutex.Unlock()
+			if err := ReloadRules(subs); err != nil {
+				go dialog.Message("Your rule changes were not applied:\n\n%v", err).Title("Invalid Rules").Error()
+			}
+		})
+		go watcher.Run(context.Background())
+
 		// Local runtime state (not persisted)
 
 		isRunning := true
@@ -244,12 +479,76 @@ func onReady() {
 
 		// --- Background Watcher ---
 
+		// Helper to count a cleaned item and move it to the front of History.
+		// previous is the offline result a resolved short link replaces, if any.
+		recordClean := func(result CleanResult, previous string) {
+			cfgMutex.Lock()
+			if previous == "" {
+				cfg.TotalCleaned++
+			}
+
+			// Update History: Move-to-Front Deduplication
+			var newHistory []HistoryEntry
+			for _, item := range cfg.History {
+				if item.Output != redactSecrets(result.Output) && item.Output != redactSecrets(previous) {
+					newHistory = append(newHistory, item)
+				}
+			}
+			cfg.History = append([]HistoryEntry{{result.redacted()}}, newHistory...)
+			if len(cfg.History) > 5 {
+				cfg.History = cfg.History[:5]
+			}
+
+			SaveConfig(cfg) // Auto-save on count change
+			total := cfg.TotalCleaned
+			playSound := cfg.Sound
+			cfgMutex.Unlock()
+
+			mCounter.SetTitle(fmt.Sprintf("Cleaned: %d Items", total))
+			updateHistoryMenu()
+			if playSound {
+				NotifyBeep()
+			}
+		}
+
+		// unshortened carries the result of a short link resolved in the
+		// background, together with the clipboard text it is meant to replace.
+		type unshortened struct {
+			written string
+			offline CleanResult
+			result  CleanResult
+		}
+		unshortenedCh := make(chan unshortened)
+
 		go func() {
 
 			lastText, _ := clipboard.ReadAll()
+			cancelUnshorten := func() {}
 
 			for {
 
+				// Swap in a resolved short link, unless the user copied something else meanwhile
+				select {
+				case u := <-unshortenedCh:
+					if current, err := clipboard.ReadAll(); err != nil || current != u.written {
+						break
+					}
+					if !slices.Equal(u.result.Warnings, u.offline.Warnings) && len(u.result.Warnings) > 0 {
+						showWarnings(u.result)
+					}
+					if u.result.Output == u.written {
+						break
+					}
+					clipboard.WriteAll(u.result.Output)
+					lastText = u.result.Output
+					previous := ""
+					if u.offline.Changed() {
+						previous = u.offline.Output
+					}
+					recordClean(u.result, previous)
+				default:
+				}
+
 				if !isRunning {
 
 					time.Sleep(1 * time.Second)
@@ -264,73 +563,46 @@ func onReady() {
 
 				if err == nil && text != "" && text != lastText {
 
-	
+					// A new copy makes any pending lookup pointless
+					cancelUnshorten()
 
 					cfgMutex.Lock()
+					opts := cfg.CleanOptions()
+					cfgMutex.Unlock()
 
-					cleaned := CleanText(text, cfg.Unshorten, cfg.WSLMode, cfg.DirectLink)
+					// Clean offline right away; short links that are not cached yet
+					// are resolved in the background without holding up the clipboard
+					offlineOpts := opts
+					offlineOpts.Offline = true
+					result := Clean(text, offlineOpts)
+					cleaned := result.Output
+
+					if result.NeedsNetwork() {
+						ctx, cancel := context.WithCancel(context.Background())
+						cancelUnshorten = cancel
+						go func() {
+							defer cancel()
+							resolved := CleanContext(ctx, text, opts)
+							select {
+							case unshortenedCh <- unshortened{written: cleaned, offline: result, result: resolved}:
+							case <-ctx.Done():
+							}
+						}()
+					}
 
-					cfgMutex.Unlock()
+					if len(result.Warnings) > 0 {
+						showWarnings(result)
+					}
 
 	
 
-					if cleaned != text {
+					if result.Changed() {
 
 						clipboard.WriteAll(cleaned)
 
 						lastText = cleaned
 
-	
-
-						cfgMutex.Lock()
-
-						cfg.TotalCleaned++
-
-												// Update History: Move-to-Front Deduplication
-
-												var newHistory []string
-
-												for _, item := range cfg.History {
-
-													if item != cleaned {
-
-														newHistory = append(newHistory, item)
-
-													}
-
-												}
-
-												cfg.History = append([]string{cleaned}, newHistory...)
-
-												if len(cfg.History) > 5 {
-
-													cfg.History = cfg.History[:5]
-
-												}
-
-						SaveConfig(cfg) // Auto-save on count change
-
-						cfgMutex.Unlock()
-
-	
-
-						mCounter.SetTitle(fmt.Sprintf("Cleaned: %d Items", cfg.TotalCleaned))
-
-						updateHistoryMenu()
-
-	
-
-						cfgMutex.Lock()
-
-						playSound := cfg.Sound
-
-						cfgMutex.Unlock()
-
-						if playSound {
-
-							NotifyBeep()
-
-						}
+						recordClean(result, "")
 
 					} else {
 
@@ -362,6 +634,17 @@ func onReady() {
 
 	
 
+				case <-mWarning.ClickedCh:
+					cfgMutex.Lock()
+					details, title := lastWarning, lastWarningTitle
+					cfgMutex.Unlock()
+					if title == "Suspicious Link" {
+						dialog.Message("%s", details).Title(title).Error()
+					} else {
+						dialog.Message("%s", details).Title(title).Info()
+					}
+					mWarning.Hide()
+
 				case <-mPause.ClickedCh:
 
 					if isRunning {
@@ -384,13 +667,49 @@ func onReady() {
 
 	
 
+				case idx := <-sourceClicked:
+					cfgMutex.Lock()
+					if idx >= len(cfg.Subscriptions) {
+						cfgMutex.Unlock()
+						break
+					}
+					sub := &cfg.Subscriptions[idx]
+					sub.Enabled = !sub.Enabled
+					if sub.Enabled {
+						mSourceItems[idx].Check()
+					} else {
+						mSourceItems[idx].Uncheck()
+					}
+					subs := slices.Clone(cfg.Subscriptions)
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+					if err := LoadRules(subs); err != nil {
+						dialog.Message("Some rules could not be loaded:\n\n%v", err).Title("Rule Sources").Error()
+					}
+					updateSourcesMenu()
+					NotifyBeep()
+
+				case <-mAutoUpdate.ClickedCh:
+					cfgMutex.Lock()
+					if cfg.AutoUpdate {
+						cfg.AutoUpdate = false
+						mAutoUpdate.Uncheck()
+					} else {
+						cfg.AutoUpdate = true
+						mAutoUpdate.Check()
+						NotifyBeep()
+					}
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+					scheduler.Wake()
+
 				case idx := <-historyClicked:
 
 					cfgMutex.Lock()
 
 					if idx < len(cfg.History) {
 
-						clipboard.WriteAll(cfg.History[idx])
+						clipboard.WriteAll(cfg.History[idx].Output)
 
 						if cfg.Sound {
 
@@ -508,6 +827,88 @@ func onReady() {
 
 	
 
+				case <-mDeAMP.ClickedCh:
+					cfgMutex.Lock()
+					if cfg.DeAMP {
+						cfg.DeAMP = false
+						mDeAMP.Uncheck()
+					} else {
+						cfg.DeAMP = true
+						mDeAMP.Check()
+						NotifyBeep()
+					}
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+
+				case <-mDetectShort.ClickedCh:
+					cfgMutex.Lock()
+					if cfg.DetectShortLinks {
+						cfg.DetectShortLinks = false
+						mDetectShort.Uncheck()
+					} else {
+						cfg.DetectShortLinks = true
+						mDetectShort.Check()
+						NotifyBeep()
+					}
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+
+				case <-mInText.ClickedCh:
+					cfgMutex.Lock()
+					if cfg.CleanInText {
+						cfg.CleanInText = false
+						mInText.Uncheck()
+					} else {
+						cfg.CleanInText = true
+						mInText.Check()
+						NotifyBeep()
+					}
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+
+				case <-mTextFrag.ClickedCh:
+					cfgMutex.Lock()
+					if cfg.StripTextFragments {
+						cfg.StripTextFragments = false
+						mTextFrag.Uncheck()
+					} else {
+						cfg.StripTextFragments = true
+						mTextFrag.Check()
+						NotifyBeep()
+					}
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+
+				case <-mCatTracking.ClickedCh:
+					toggleCategory(mCatTracking, CategoryTracking)
+
+				case <-mCatAffiliate.ClickedCh:
+					toggleCategory(mCatAffiliate, CategoryAffiliate)
+
+				case <-mCatSocial.ClickedCh:
+					toggleCategory(mCatSocial, CategorySocialShare)
+
+				case <-mCatAnalytics.ClickedCh:
+					toggleCategory(mCatAnalytics, CategoryAnalytics)
+
+				case <-mIDNKeep.ClickedCh:
+					setIDNPolicy(IDNKeep)
+
+				case <-mIDNUnicode.ClickedCh:
+					setIDNPolicy(IDNUnicode)
+
+				case <-mIDNPunycode.ClickedCh:
+					setIDNPolicy(IDNPunycode)
+
+				case <-mCredsStrip.ClickedCh:
+					setCredentialPolicy(CredentialStrip)
+
+				case <-mCredsKeep.ClickedCh:
+					setCredentialPolicy(CredentialKeep)
+
+				case <-mCredsWarn.ClickedCh:
+					setCredentialPolicy(CredentialWarn)
+
 				case <-mStartup.ClickedCh:
 
 					if app.IsEnabled() {
@@ -552,15 +953,31 @@ func onReady() {
 
 				case <-mUpdate.ClickedCh:
 
-					err := UpdateFilters()
+					cfgMutex.Lock()
+					subs := slices.Clone(cfg.Subscriptions)
+					trustedKeys := slices.Clone(cfg.TrustedKeys)
+					cfgMutex.Unlock()
 
-					if err != nil {
+					subs, err := UpdateFilters(subs, trustedKeys)
+					recordSubscriptions(subs)
+
+					if errors.Is(err, ErrRulesUpToDate) {
+
+						dialog.Message("Filters are already up to date.").Title("Up to Date").Info()
+
+					} else if err != nil {
 
 						dialog.Message("Update failed: %v", err).Title("Error").Error()
 
 					} else {
 
-						dialog.Message("Filters updated successfully!").Title("Success").Info()
+						var changes []string
+						for _, sub := range subs {
+							if sub.JustChanged() {
+								changes = append(changes, sub.Name+":\n"+sub.Changes.Summary(10))
+							}
+						}
+						dialog.Message("Filters updated successfully!\n\n%s", strings.Join(changes, "\n\n")).Title("Success").Info()
 
 						NotifyBeep()
 
@@ -644,6 +1061,76 @@ func onReady() {
 
 					NotifyBeep()
 
+				case <-tExplain.ClickedCh:
+					cfgMutex.Lock()
+					var report []string
+					for _, item := range cfg.History {
+						report = append(report, item.Output+"\n"+item.Summary())
+					}
+					cfgMutex.Unlock()
+					if len(report) == 0 {
+						report = append(report, "No links have been cleaned yet.")
+					}
+					dialog.Message("%s", strings.Join(report, "\n\n")).Title("What Was Stripped").Info()
+
+				case <-tNeverClean.ClickedCh:
+					text, _ := clipboard.ReadAll()
+					host, ok := neverCleanHost(text)
+					if !ok {
+						dialog.Message("Copy a link from the domain you want to exempt first.").Title("Never Clean This Domain").Info()
+						break
+					}
+					if !dialog.Message("Never clean links from %s?", host).Title("Never Clean This Domain").YesNo() {
+						break
+					}
+					cfgMutex.Lock()
+					if !slices.Contains(cfg.NeverClean, host) {
+						cfg.NeverClean = append(cfg.NeverClean, host)
+						SaveConfig(cfg)
+					}
+					cfgMutex.Unlock()
+					dialog.Message("Links from %s will be left untouched.\nRemove it from \"never_clean\" in %s to undo.", host, configFileName).Title("Domain Exempted").Info()
+					NotifyBeep()
+
+				case <-tClearCache.ClickedCh:
+					n, err := ClearUnshortenCache()
+					if err != nil {
+						dialog.Message("Could not clear the unshorten cache: %v", err).Title("Error").Error()
+						break
+					}
+					dialog.Message("Forgot %d short links. They will be looked up again the next time you copy them.", n).Title("Unshorten Cache Cleared").Info()
+					NotifyBeep()
+
+				case <-tRollback.ClickedCh:
+					rb, err := PreviewRollback()
+					if errors.Is(err, ErrNoBackups) {
+						dialog.Message("There are no previous rules to roll back to.").Title("Roll Back Rules").Info()
+						break
+					} else if err != nil {
+						dialog.Message("Rollback failed: %v", err).Title("Error").Error()
+						break
+					}
+					if !dialog.Message("Restore %s from %s?\n\nThis will change:\n%s", rb.File, rb.Taken.Format("2006-01-02 15:04"), rb.Diff.Summary(15)).Title("Roll Back Rules").YesNo() {
+						break
+					}
+					// Don't let the next update bring back the version being rolled back
+					cfgMutex.Lock()
+					for i := range cfg.Subscriptions {
+						if cfg.Subscriptions[i].file() == rb.File {
+							cfg.Subscriptions[i].SkipVersion = rb.Version
+							cfg.Subscriptions[i].SkipHash = rb.Hash
+						}
+					}
+					subs := slices.Clone(cfg.Subscriptions)
+					SaveConfig(cfg)
+					cfgMutex.Unlock()
+					if err := rb.Apply(subs); err != nil {
+						dialog.Message("Rollback failed: %v", err).Title("Error").Error()
+						break
+					}
+					dialog.Message("Rules restored from %s.", rb.Taken.Format("2006-01-02 15:04")).Title("Roll Back Rules").Info()
+					NotifyBeep()
+
 				}
 
 			}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// PathRule rewrites the path of matching links, e.g. collapsing Amazon
+// product pages to /dp/<ASIN>. Pattern is matched against the escaped path
+// and Replace may refer to capture groups as $1. Without Hosts the rule
+// applies to every link.
+type PathRule struct {
+	Name    string     `json:"name,omitempty"`
+	Hosts   []string   `json:"hosts,omitempty"`
+	Pattern string     `json:"pattern"`
+	Replace string     `json:"replace"`
+	Tests   []RuleTest `json:"tests,omitempty"`
+}
+
+var defaultPathRules = []PathRule{
+	{
+		Name:    "amazon product",
+		Hosts:   []string{"amazon.*"},
+		Pattern: `^/(?:.*/)?(?:dp|gp/product|gp/aw/d)/([A-Z0-9]{10})(?:/.*)?$`,
+		Replace: "/dp/$1",
+	},
+	{Name: "amazon ref", Hosts: []string{"amazon.*"}, Pattern: `/ref=[^/]*$`, Replace: ""},
+	{Name: "jsessionid", Pattern: `(?i);jsessionid=[^/;]*`, Replace: ""},
+}
+
+// compiledPathRule is a PathRule with its pattern compiled.
+type compiledPathRule struct {
+	name    string
+	hosts   []string
+	re      *regexp.Regexp
+	replace string
+}
+
+func compilePathRules(rules []PathRule) ([]compiledPathRule, error) {
+	compiled := make([]compiledPathRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("path rule %q: %v", rule.Name, err)
+		}
+		compiled = append(compiled, compiledPathRule{
+			name:    rule.Name,
+			hosts:   rule.Hosts,
+			re:      re,
+			replace: rule.Replace,
+		})
+	}
+	return compiled, nil
+}
+
+// rewritePath applies every matching path rule to u, recording each change
+// in res.
+func (rs *ruleSet) rewritePath(u *url.URL, res *CleanResult) {
+	for _, rule := range rs.pathRules {
+		if len(rule.hosts) > 0 && !anyHostMatches(u.Hostname(), rule.hosts) {
+			continue
+		}
+		before := u.EscapedPath()
+		after := rule.re.ReplaceAllString(before, rule.replace)
+		if after == before {
+			continue
+		}
+		if after == "" {
+			after = "/"
+		}
+		unescaped, err := url.PathUnescape(after)
+		if err != nil {
+			continue
+		}
+		u.Path, u.RawPath = unescaped, after
+		res.add(ActionRewrotePath, before+" → "+after, rule.name)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// acePrefix marks a punycode-encoded (RFC 3492) label.
+const acePrefix = "xn--"
+
+// hostToASCII converts every non-ASCII label of host to its "xn--" form.
+// Labels are lowercased but not otherwise IDNA-mapped; that is enough to
+// compare and display hosts, not to register them.
+func hostToASCII(host string) (string, error) {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		encoded, err := idna.Punycode.ToASCII(strings.ToLower(label))
+		if err != nil {
+			return host, err
+		}
+		labels[i] = encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// hostToUnicode decodes every "xn--" label of host.
+func hostToUnicode(host string) (string, error) {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if len(label) < len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			continue
+		}
+		decoded, err := idna.Punycode.ToUnicode(strings.ToLower(label))
+		if err != nil {
+			return host, err
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
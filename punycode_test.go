@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestHostPunycodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		unicode, ascii string
+	}{
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"аpple.com", "xn--pple-43d.com"},
+		// The delta passes 0x10FFFF while decoding; RFC 3492 only bounds it by maxint.
+		{"abcdefghij😀.com", "xn--abcdefghij-t366i.com"},
+		{"plain.example", "plain.example"},
+	}
+	for _, tt := range tests {
+		ascii, err := hostToASCII(tt.unicode)
+		if err != nil || ascii != tt.ascii {
+			t.Errorf("hostToASCII(%q) = %q, %v; want %q", tt.unicode, ascii, err, tt.ascii)
+		}
+		back, err := hostToUnicode(ascii)
+		if err != nil || back != tt.unicode {
+			t.Errorf("hostToUnicode(%q) = %q, %v; want %q", ascii, back, err, tt.unicode)
+		}
+	}
+}
+
+func TestHostToUnicodeUppercasePrefix(t *testing.T) {
+	got, err := hostToUnicode("XN--BCHER-KVA.example")
+	if err != nil || got != "bücher.example" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestHostToUnicodeMalformed(t *testing.T) {
+	if _, err := hostToUnicode("xn--ab-@.com"); err == nil {
+		t.Error("malformed label decoded without error")
+	}
+}
+
+func TestHostWarningsValidEmoji(t *testing.T) {
+	for _, w := range hostWarnings("xn--abcdefghij-t366i.com") {
+		t.Errorf("unexpected warning %q", w)
+	}
+}
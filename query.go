@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// queryParam is one "key=value" pair of a raw query, kept exactly as copied.
+type queryParam struct {
+	key string // decoded name, used for matching
+	raw string // original bytes, including the value and its encoding
+}
+
+// rawQuery edits a query string without re-encoding it. Unlike url.Values it
+// keeps the original order, duplicate keys and percent-encoding, so removing
+// a tracker does not disturb signed or order-sensitive URLs.
+type rawQuery []queryParam
+
+func parseRawQuery(s string) rawQuery {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, "&")
+	q := make(rawQuery, 0, len(parts))
+	for _, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		q = append(q, queryParam{key: name, raw: part})
+	}
+	return q
+}
+
+func (q rawQuery) String() string {
+	parts := make([]string, len(q))
+	for i, p := range q {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// removeIf drops every pair whose key satisfies match and returns the
+// removed keys in their original order.
+func (q *rawQuery) removeIf(match func(key string) bool) []string {
+	var removed []string
+	kept := (*q)[:0]
+	for _, p := range *q {
+		if p.key != "" && match(p.key) {
+			removed = append(removed, p.key)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	*q = kept
+	return removed
+}
+
+// set replaces the first pair named key in place, drops any duplicates,
+// and appends the pair if it was missing.
+func (q *rawQuery) set(key, value string) {
+	p := queryParam{key: key, raw: url.QueryEscape(key) + "=" + url.QueryEscape(value)}
+	found := false
+	kept := (*q)[:0]
+	for _, existing := range *q {
+		if existing.key != key {
+			kept = append(kept, existing)
+		} else if !found {
+			kept = append(kept, p)
+			found = true
+		}
+	}
+	if !found {
+		kept = append(kept, p)
+	}
+	*q = kept
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxUnwrapDepth bounds how many nested redirect wrappers are peeled off.
+const maxUnwrapDepth = 5
+
+// RedirectRule describes a wrapper URL that carries its real destination in
+// a query parameter, e.g. google.com/url?q=<target>.
+type RedirectRule struct {
+	Hosts []string `json:"hosts"`
+	// Path is a path prefix the wrapper must start with; empty matches any path.
+	Path string `json:"path,omitempty"`
+	// Params are checked in order; the first one holding a URL wins.
+	Params []string   `json:"params"`
+	Tests  []RuleTest `json:"tests,omitempty"`
+}
+
+var defaultRedirects = []RedirectRule{
+	{Hosts: []string{"google.*"}, Path: "/url", Params: []string{"q", "url"}},
+	{Hosts: []string{"l.facebook.com", "lm.facebook.com", "l.messenger.com"}, Path: "/l.php", Params: []string{"u"}},
+	{Hosts: []string{"l.instagram.com"}, Params: []string{"u"}},
+	{Hosts: []string{"safelinks.protection.outlook.com"}, Params: []string{"url"}},
+	{Hosts: []string{"slack-redir.net"}, Path: "/link", Params: []string{"url"}},
+	{Hosts: []string{"steamcommunity.com"}, Path: "/linkfilter", Params: []string{"url", "u"}},
+	{Hosts: []string{"youtube.com"}, Path: "/redirect", Params: []string{"q"}},
+}
+
+// unwrapRedirects replaces redirect wrappers with the URL they embed, without
+// any network access. Nested wrappers are peeled off up to maxUnwrapDepth.
+func (rs *ruleSet) unwrapRedirects(rawURL string, res *CleanResult) string {
+	for i := 0; i < maxUnwrapDepth; i++ {
+		target, ok := rs.redirectTarget(rawURL)
+		if !ok {
+			break
+		}
+		res.add(ActionUnwrapped, hostOf(rawURL), "")
+		rawURL = target
+	}
+	return rawURL
+}
+
+// redirectTarget returns the destination embedded in rawURL by a wrapper
+// rule or a provider redirection.
+func (rs *ruleSet) redirectTarget(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+
+	for _, rule := range rs.redirects {
+		if !anyHostMatches(u.Hostname(), rule.Hosts) || !strings.HasPrefix(u.Path, rule.Path) {
+			continue
+		}
+		q := u.Query()
+		for _, param := range rule.Params {
+			if target, ok := decodeTarget(q.Get(param)); ok {
+				return target, true
+			}
+		}
+	}
+
+	for _, rule := range rs.rulesFor(rawURL, u.Hostname(), nil) {
+		if target, ok := rule.redirectTarget(rawURL); ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// decodeTarget accepts an embedded URL that may have been percent-encoded
+// more than once and returns it once it is an absolute http(s) URL.
+func decodeTarget(value string) (string, bool) {
+	for i := 0; i < 3 && value != ""; i++ {
+		if u, err := url.Parse(value); err == nil && u.Host != "" &&
+			(u.Scheme == "http" || u.Scheme == "https") {
+			return value, true
+		}
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil || unescaped == value {
+			break
+		}
+		value = unescaped
+	}
+	return "", false
+}
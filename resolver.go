@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Unshortening limits. Only the redirect chain matters, so responses are
+// never read beyond maxResolveBody.
+const (
+	resolveTimeout     = 3 * time.Second
+	maxResolveRedirect = 10
+	maxResolveBody     = 4 << 10
+	maxResolveHeader   = 16 << 10
+)
+
+// blockedNetworks are ranges a short link must not reach unless the user
+// allowed them: "this" network, carrier-grade NAT, IETF protocol
+// assignments, benchmarking, reserved, and the IPv6 discard and NAT64
+// prefixes. Loopback, private, link-local (including the 169.254.169.254
+// cloud metadata service), multicast and unspecified addresses are checked
+// with the netip predicates.
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// errBlockedAddress is returned when a short link leads to a private address.
+type errBlockedAddress struct {
+	addr netip.Addr
+}
+
+func (e *errBlockedAddress) Error() string {
+	return fmt.Sprintf("refused to connect to private address %s", e.addr)
+}
+
+// parseNetworks parses allowlist entries, each a CIDR range or a single address.
+func parseNetworks(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			p, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// publicAddress reports whether unshortening may connect to addr.
+func publicAddress(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range allowed {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, p := range blockedNetworks {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newResolveClient returns the client used to follow short links. The
+// address check runs in the dialer's Control hook, on the IP actually being
+// connected to after DNS resolution, so a host cannot pass the check with
+// one address and then connect to another. Proxies are not used, since
+// they would resolve and connect on PureLink's behalf.
+func newResolveClient(allowed []netip.Prefix) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: resolveTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addrPort.Addr(), allowed) {
+				return &errBlockedAddress{addrPort.Addr().Unmap()}
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    resolveTimeout,
+		ResponseHeaderTimeout:  resolveTimeout,
+		MaxResponseHeaderBytes: maxResolveHeader,
+		DisableKeepAlives:      true,
+		ForceAttemptHTTP2:      true,
+	}
+	return &http.Client{
+		Timeout:   resolveTimeout,
+		Transport: cappedTransport{transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxResolveRedirect {
+				return http.ErrUseLastResponse
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("refused to follow redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// cappedTransport stops every response body after maxResolveBody bytes,
+// including the redirect bodies net/http drains between hops.
+type cappedTransport struct {
+	http.RoundTripper
+}
+
+func (t cappedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, maxResolveBody), resp.Body}
+	return resp, nil
+}
+
+// resolveURL follows the redirects of shortURL and returns where they end,
+// giving up when ctx is done. Addresses outside allowed that are not public
+// are never connected to.
+func resolveURL(ctx context.Context, shortURL string, allowed []netip.Prefix) (string, error) {
+	client := newResolveClient(allowed)
+	resp, err := resolveRequest(ctx, client, http.MethodHead, shortURL)
+	var blocked *errBlockedAddress
+	if err != nil && !errors.As(err, &blocked) && ctx.Err() == nil {
+		// Some shorteners do not answer HEAD; the GET body is closed unread
+		resp, err = resolveRequest(ctx, client, http.MethodGet, shortURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Request.URL.String(), nil
+}
+
+func resolveRequest(ctx context.Context, client *http.Client, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	allowed, err := parseNetworks([]string{"10.20.0.0/16", " 192.168.1.5 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"255.255.255.255", false},
+		{"64:ff9b::a00:1", false},
+		// Allowed networks
+		{"10.20.3.4", true},
+		{"::ffff:10.20.3.4", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(netip.MustParseAddr(tt.addr), allowed); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if _, err := parseNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid prefix accepted")
+	}
+	if _, err := parseNetworks([]string{"intranet"}); err == nil {
+		t.Error("invalid address accepted")
+	}
+}
+
+// shortServer is a local shortener: /s redirects to /dest, and /to?u=
+// redirects to any URL.
+func shortServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/s":
+			http.Redirect(w, r, "/dest", http.StatusFound)
+		case "/to":
+			http.Redirect(w, r, r.URL.Query().Get("u"), http.StatusFound)
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 10*maxResolveBody)))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResolveURLBlocksPrivateAddresses(t *testing.T) {
+	srv := shortServer(t)
+	loopback, _ := parseNetworks([]string{"127.0.0.1"})
+	port := srv.URL[strings.LastIndex(srv.URL, ":")+1:]
+
+	tests := []struct {
+		name    string
+		url     string
+		allowed []netip.Prefix
+		blocked string
+	}{
+		{"loopback", srv.URL + "/s", nil, "127.0.0.1"},
+		{"IPv4-mapped loopback", "http://[::ffff:127.0.0.1]:" + port + "/s", nil, "127.0.0.1"},
+		{"redirect to metadata service", srv.URL + "/to?u=http://169.254.169.254/latest/meta-data/", loopback, "169.254.169.254"},
+		{"redirect to private network", srv.URL + "/to?u=http://10.0.0.1/", loopback, "10.0.0.1"},
+		{"redirect to IPv6 loopback", srv.URL + "/to?u=http://[::1]:" + port + "/", loopback, "::1"},
+	}
+	for _, tt := range tests {
+		_, err := resolveURL(context.Background(), tt.url, tt.allowed)
+		var blocked *errBlockedAddress
+		if !errors.As(err, &blocked) {
+			t.Errorf("%s: err = %v, want a blocked address", tt.name, err)
+			continue
+		}
+		if blocked.addr.String() != tt.blocked {
+			t.Errorf("%s: blocked %s, want %s", tt.name, blocked.addr, tt.blocked)
+		}
+	}
+}
+
+func TestResolveURLAllowlist(t *testing.T) {
+	srv := shortServer(t)
+	loopback, _ := parseNetworks([]string{"127.0.0.0/8"})
+
+	got, err := resolveURL(context.Background(), srv.URL+"/s", loopback)
+	if err != nil || got != srv.URL+"/dest" {
+		t.Errorf("allowed network: got %q, %v", got, err)
+	}
+	if _, err := resolveURL(context.Background(), srv.URL+"/to?u=file:///etc/passwd", loopback); err == nil {
+		t.Error("redirect to file: followed")
+	}
+}
+
+func TestCappedTransport(t *testing.T) {
+	srv := shortServer(t)
+	client := &http.Client{Transport: cappedTransport{http.DefaultTransport}}
+	resp, err := client.Get(srv.URL + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != maxResolveBody {
+		t.Errorf("read %d bytes, want %d", len(body), maxResolveBody)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ActionKind identifies one kind of change made by Clean.
+type ActionKind string
+
+const (
+	ActionRemovedParam ActionKind = "removed_param"
+	ActionUnwrapped    ActionKind = "unwrapped"
+	ActionUnshortened  ActionKind = "unshortened"
+	ActionRawRule      ActionKind = "raw_rule"
+	ActionShorts       ActionKind = "shorts"
+	ActionDirectLink   ActionKind = "direct_link"
+	ActionPath         ActionKind = "path"
+	ActionRewrotePath  ActionKind = "rewrote_path"
+	ActionTextFragment ActionKind = "text_fragment"
+	ActionAMP          ActionKind = "amp"
+	ActionCanonical    ActionKind = "canonical"
+	ActionCredentials  ActionKind = "credentials"
+)
+
+// Action records a single change and, where relevant, the rule behind it.
+type Action struct {
+	Kind   ActionKind `json:"kind"`
+	Detail string     `json:"detail"`
+	Rule   string     `json:"rule,omitempty"`
+}
+
+// CleanResult is the cleaned value together with an explanation of what changed.
+type CleanResult struct {
+	Input   string   `json:"input"`
+	Output  string   `json:"output"`
+	Actions []Action `json:"actions,omitempty"`
+	// Warnings flag suspicious links, such as lookalike domains, that were
+	// passed through but deserve the user's attention.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// unresolved is set when an offline pass skipped short links.
+	unresolved bool
+	// lookalike is set when a warning is about a possibly impersonated site,
+	// rather than about credentials or a link that was not followed.
+	lookalike bool
+}
+
+// Changed reports whether cleaning modified the input.
+func (r *CleanResult) Changed() bool {
+	return r.Output != r.Input
+}
+
+// NeedsNetwork reports whether an Offline clean skipped short links that
+// are not in the unshorten cache yet.
+func (r *CleanResult) NeedsNetwork() bool {
+	return r.unresolved
+}
+
+func (r *CleanResult) add(kind ActionKind, detail, rule string) {
+	r.Actions = append(r.Actions, Action{Kind: kind, Detail: detail, Rule: rule})
+}
+
+func (r *CleanResult) warn(warnings ...string) {
+	for _, w := range warnings {
+		if !slices.Contains(r.Warnings, w) {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+}
+
+// warnLookalike records warnings about lookalike domains and misleading
+// link text.
+func (r *CleanResult) warnLookalike(warnings ...string) {
+	r.warn(warnings...)
+	r.lookalike = r.lookalike || len(warnings) > 0
+}
+
+// Lookalike reports whether any warning says the link may impersonate
+// another site.
+func (r *CleanResult) Lookalike() bool {
+	return r.lookalike
+}
+
+// String describes the action in a sentence suitable for the tray.
+func (a Action) String() string {
+	var s string
+	switch a.Kind {
+	case ActionRemovedParam:
+		s = "Removed parameter " + a.Detail
+	case ActionUnwrapped:
+		s = "Unwrapped redirect from " + a.Detail
+	case ActionUnshortened:
+		s = "Unshortened " + a.Detail
+	case ActionRawRule:
+		s = "Removed " + a.Detail
+	case ActionShorts:
+		s = "Converted YouTube Short " + a.Detail
+	case ActionDirectLink:
+		s = "Converted to direct download (" + a.Detail + ")"
+	case ActionPath:
+		s = "Converted path " + a.Detail
+	case ActionRewrotePath:
+		s = "Rewrote URL path " + a.Detail
+	case ActionTextFragment:
+		s = "Removed text highlight " + a.Detail
+	case ActionAMP:
+		s = "Removed AMP " + a.Detail
+	case ActionCanonical:
+		s = "Normalized: " + a.Detail
+	case ActionCredentials:
+		s = "Removed credentials: " + a.Detail
+	default:
+		s = fmt.Sprintf("%s %s", a.Kind, a.Detail)
+	}
+	if a.Rule != "" {
+		s += " [" + a.Rule + "]"
+	}
+	return s
+}
+
+// Summary lists every action on its own line, or explains that nothing changed.
+func (r *CleanResult) Summary() string {
+	var lines []string
+	for _, w := range r.Warnings {
+		lines = append(lines, "⚠ "+w)
+	}
+	for _, a := range r.Actions {
+		lines = append(lines, "• "+a.String())
+	}
+	if len(r.Actions) == 0 {
+		lines = append(lines, "Nothing was changed.")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ruleHistoryDir keeps the rule files replaced by updates, named
+// "<timestamp>_<file>" so they sort by age.
+const ruleHistoryDir = "rules_history"
+
+// maxRuleBackups is how many previous versions are kept per rule file.
+const maxRuleBackups = 5
+
+const backupTimeFormat = "20060102-150405.000"
+
+// ErrNoBackups is returned by PreviewRollback when there is nothing to restore.
+var ErrNoBackups = errors.New("no previous rules to roll back to")
+
+// RuleDiff lists the rule entries an update added and removed.
+type RuleDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether the diff has no changes.
+func (d RuleDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// Summary lists up to limit changes, one per line, prefixed with + or -.
+func (d RuleDiff) Summary(limit int) string {
+	if d.Empty() {
+		return "No rule changes."
+	}
+	var lines []string
+	for _, e := range d.Added {
+		lines = append(lines, "+ "+e)
+	}
+	for _, e := range d.Removed {
+		lines = append(lines, "- "+e)
+	}
+	if len(lines) > limit {
+		lines = append(lines[:limit], fmt.Sprintf("...and %d more", len(lines)-limit))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diffRules compares two rule files entry by entry. A nil old config counts as empty.
+func diffRules(old, new *RuleConfig) RuleDiff {
+	before, after := ruleEntries(old), ruleEntries(new)
+	var d RuleDiff
+	for _, e := range after {
+		if !slices.Contains(before, e) {
+			d.Added = append(d.Added, e)
+		}
+	}
+	for _, e := range before {
+		if !slices.Contains(after, e) {
+			d.Removed = append(d.Removed, e)
+		}
+	}
+	return d
+}
+
+// ruleEntries flattens config into one readable line per parameter,
+// pattern or wrapper, resolving the built-in lists it falls back to.
+func ruleEntries(config *RuleConfig) []string {
+	if config == nil {
+		return nil
+	}
+	var entries []string
+	for _, p := range config.Blocklist {
+		entries = append(entries, "param "+p)
+	}
+	for _, rule := range config.Rules {
+		scope := rule.Name
+		if scope == "" {
+			scope = strings.Join(rule.Hosts, ", ")
+		}
+		for _, p := range rule.Params {
+			entries = append(entries, fmt.Sprintf("param %s on %s", p, scope))
+		}
+		for _, r := range rule.RawRules {
+			entries = append(entries, fmt.Sprintf("raw rule %s on %s", r, scope))
+		}
+	}
+	for _, e := range config.Exceptions {
+		entries = append(entries, fmt.Sprintf("exception %s%s %s",
+			strings.Join(e.Hosts, ", "), e.PathPrefix, strings.Join(e.Params, ", ")))
+	}
+
+	pathRules := config.PathRules
+	if pathRules == nil {
+		pathRules = defaultPathRules
+	}
+	for _, r := range pathRules {
+		entries = append(entries, fmt.Sprintf("path rule %s: %s → %s", r.Name, r.Pattern, r.Replace))
+	}
+	redirects := config.Redirects
+	if redirects == nil {
+		redirects = defaultRedirects
+	}
+	for _, r := range redirects {
+		entries = append(entries, fmt.Sprintf("redirect %s%s (%s)",
+			strings.Join(r.Hosts, ", "), r.Path, strings.Join(r.Params, ", ")))
+	}
+	secrets := config.SecretParams
+	if secrets == nil {
+		secrets = defaultSecretParams
+	}
+	for _, p := range secrets {
+		entries = append(entries, "secret param "+p)
+	}
+	shorteners := config.Shorteners
+	if shorteners == nil {
+		shorteners = defaultShorteners
+	}
+	for _, s := range shorteners {
+		entries = append(entries, "shortener "+s)
+	}
+	return entries
+}
+
+// ruleHash identifies the content of config, for rule sets without a version.
+func ruleHash(config *RuleConfig) string {
+	if config == nil {
+		return ""
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// backupRules copies the rule file name into the history before an update
+// replaces it, keeping only the newest maxRuleBackups copies.
+func backupRules(name string) error {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ruleHistoryDir, 0o755); err != nil {
+		return err
+	}
+	base := filepath.Base(name)
+	backup := filepath.Join(ruleHistoryDir, time.Now().Format(backupTimeFormat)+"_"+base)
+	if err := os.WriteFile(backup, data, 0o644); err != nil {
+		return err
+	}
+
+	backups, err := listBackups()
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].target != base {
+			continue
+		}
+		if kept++; kept > maxRuleBackups {
+			os.Remove(backups[i].path)
+		}
+	}
+	return nil
+}
+
+// ruleBackup is one file in ruleHistoryDir.
+type ruleBackup struct {
+	path   string
+	target string
+	taken  time.Time
+}
+
+// listBackups returns the backups from oldest to newest.
+func listBackups() ([]ruleBackup, error) {
+	files, err := os.ReadDir(ruleHistoryDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []ruleBackup
+	for _, f := range files {
+		stamp, target, ok := strings.Cut(f.Name(), "_")
+		if !ok || f.IsDir() {
+			continue
+		}
+		taken, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, ruleBackup{filepath.Join(ruleHistoryDir, f.Name()), target, taken})
+	}
+	slices.SortFunc(backups, func(a, b ruleBackup) int { return a.taken.Compare(b.taken) })
+	return backups, nil
+}
+
+// Rollback describes restoring the newest backup over the rule file it came from.
+type Rollback struct {
+	File    string
+	Taken   time.Time
+	Diff    RuleDiff
+	Version int64  // the version being rolled back from
+	Hash    string // its content, when it has no version
+	backup  string
+}
+
+// PreviewRollback finds the newest backup and what restoring it would change.
+func PreviewRollback() (*Rollback, error) {
+	backups, err := listBackups()
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, ErrNoBackups
+	}
+	latest := backups[len(backups)-1]
+
+	data, err := os.ReadFile(latest.path)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := parseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("backup %s is unreadable: %v", latest.path, err)
+	}
+	var current *RuleConfig
+	if data, err := os.ReadFile(latest.target); err == nil {
+		current, _ = parseRules(data)
+	}
+
+	rb := &Rollback{
+		File:   latest.target,
+		Taken:  latest.taken,
+		Diff:   diffRules(current, previous),
+		backup: latest.path,
+	}
+	if current != nil {
+		rb.Version = current.Version
+		if current.Version == 0 {
+			rb.Hash = ruleHash(current)
+		}
+	}
+	return rb, nil
+}
+
+// Apply restores the backup, consuming it so the next rollback goes one
+// version further back, and reloads the rules of subs.
+func (rb *Rollback) Apply(subs []Subscription) error {
+	if err := os.Rename(rb.backup, rb.File); err != nil {
+		return err
+	}
+	return LoadRules(subs)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleTest is an example link and the output the rules must turn it into.
+type RuleTest struct {
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+}
+
+// maxReportedFailures keeps the update error dialog readable.
+const maxReportedFailures = 10
+
+// testRules compiles config and runs every self-test in it through
+// CleanText without touching the active rules. Tests run with all optional
+// tray features off, so they only exercise the rules themselves.
+func testRules(config *RuleConfig) error {
+	rs, err := compileRuleSet(ruleSource{config: config})
+	if err != nil {
+		return err
+	}
+
+	type namedTest struct {
+		owner string
+		test  RuleTest
+	}
+	var tests []namedTest
+	for _, t := range config.Tests {
+		tests = append(tests, namedTest{"rules.json", t})
+	}
+	for _, rule := range config.Rules {
+		name := rule.Name
+		if name == "" {
+			name = strings.Join(rule.Hosts, ", ")
+		}
+		for _, t := range rule.Tests {
+			tests = append(tests, namedTest{"rule " + name, t})
+		}
+	}
+	for _, rule := range config.PathRules {
+		for _, t := range rule.Tests {
+			tests = append(tests, namedTest{"path rule " + rule.Name, t})
+		}
+	}
+	for _, rule := range config.Redirects {
+		for _, t := range rule.Tests {
+			tests = append(tests, namedTest{"redirect " + strings.Join(rule.Hosts, ", "), t})
+		}
+	}
+
+	var failures []string
+	for _, nt := range tests {
+		got := CleanText(nt.test.Input, CleanOptions{rules: rs})
+		if got == nt.test.Expected {
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s\n  expected %s\n  got      %s",
+			nt.owner, nt.test.Input, nt.test.Expected, got))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	report := fmt.Sprintf("%d of %d rule tests failed:", len(failures), len(tests))
+	for i, f := range failures {
+		if i == maxReportedFailures {
+			report += fmt.Sprintf("\n...and %d more", len(failures)-i)
+			break
+		}
+		report += "\n" + f
+	}
+	return fmt.Errorf("%s", report)
+}
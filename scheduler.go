@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Background update timing. Failed subscriptions are retried sooner, backing
+// off from minRetryDelay up to the regular interval.
+const (
+	updateInterval = 24 * time.Hour
+	updateJitter   = 2 * time.Hour
+	minRetryDelay  = 15 * time.Minute
+)
+
+// RuleScheduler refreshes subscriptions in the background. Client and the
+// subscription URLs are all it talks to, so a local test server can stand in
+// for the real rule hosts.
+type RuleScheduler struct {
+	Client   *http.Client
+	Interval time.Duration
+	// Jitter spreads checks out by a random delay of up to this much.
+	Jitter time.Duration
+	// Load returns the subscriptions and trusted keys to use, or no
+	// subscriptions while background updates are switched off.
+	Load func() ([]Subscription, []string)
+	// Save records the subscriptions' new state after every run.
+	Save func([]Subscription)
+
+	wake chan struct{}
+}
+
+// NewRuleScheduler returns a scheduler with the default daily interval.
+func NewRuleScheduler(load func() ([]Subscription, []string), save func([]Subscription)) *RuleScheduler {
+	return &RuleScheduler{
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Interval: updateInterval,
+		Jitter:   updateJitter,
+		Load:     load,
+		Save:     save,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Run checks due subscriptions until ctx is cancelled.
+func (s *RuleScheduler) Run(ctx context.Context) {
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+	}
+	for {
+		subs, _ := s.Load()
+		timer := time.NewTimer(s.nextCheck(subs, time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		subs, trustedKeys := s.Load()
+		now := time.Now()
+		updated, _ := updateSubscriptions(s.Client, subs, trustedKeys, func(sub Subscription) bool {
+			return sub.remote() && !s.due(sub).After(now)
+		})
+		s.Save(updated)
+	}
+}
+
+// Wake makes a running scheduler re-read its subscriptions, e.g. after
+// background updates were switched on.
+func (s *RuleScheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due returns when sub should next be checked.
+func (s *RuleScheduler) due(sub Subscription) time.Time {
+	if sub.Failures > 0 {
+		return sub.LastChecked.Add(retryDelay(sub.Failures, s.Interval))
+	}
+	return sub.LastChecked.Add(s.Interval)
+}
+
+// nextCheck returns how long to wait for the earliest due subscription,
+// plus jitter.
+func (s *RuleScheduler) nextCheck(subs []Subscription, now time.Time) time.Duration {
+	wait := s.Interval
+	for _, sub := range subs {
+		if sub.Enabled && sub.remote() {
+			wait = min(wait, max(s.due(sub).Sub(now), 0))
+		}
+	}
+	if s.Jitter > 0 {
+		wait += rand.N(s.Jitter)
+	}
+	return wait
+}
+
+// retryDelay doubles minRetryDelay with every consecutive failure, up to limit.
+func retryDelay(failures int, limit time.Duration) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ruleServer is a local stand-in for a rule host. It serves body, signed
+// with key, and answers conditional requests for the current ETag with 304.
+type ruleServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	key     ed25519.PrivateKey
+	body    string
+	etag    string
+	down    bool
+	headers []http.Header // of every rules request
+}
+
+func newRuleServer(t *testing.T) (*ruleServer, string) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &ruleServer{key: key}
+	rs.Server = httptest.NewServer(http.HandlerFunc(rs.serve))
+	t.Cleanup(rs.Close)
+	return rs, base64.StdEncoding.EncodeToString(pub)
+}
+
+func (rs *ruleServer) serve(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, signatureSuffix) {
+		w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(rs.key, []byte(rs.body)))))
+		return
+	}
+	rs.headers = append(rs.headers, r.Header.Clone())
+	if r.Header.Get("If-None-Match") == rs.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", rs.etag)
+	w.Header().Set("Last-Modified", "Sun, 18 Oct 2026 08:00:00 GMT")
+	w.Write([]byte(rs.body))
+}
+
+func (rs *ruleServer) set(body, etag string, down bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.body, rs.etag, rs.down = body, etag, down
+}
+
+func (rs *ruleServer) lastHeader() http.Header {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.headers) == 0 {
+		return nil
+	}
+	return rs.headers[len(rs.headers)-1]
+}
+
+const testRulesV5 = `{"version": 5, "blocklist": ["served_param"],
+	"tests": [{"input": "https://example.com/?served_param=1", "expected": "https://example.com/"}]}`
+
+func TestRuleSchedulerRun(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv, pub := newRuleServer(t)
+	srv.set(testRulesV5, `"v5"`, false)
+
+	var mu sync.Mutex
+	subs := []Subscription{{Name: "Stand-in", URL: srv.URL + "/rules.json", Enabled: true}}
+	saved := make(chan []Subscription)
+	s := NewRuleScheduler(func() ([]Subscription, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return subs, []string{pub}
+	}, func(updated []Subscription) {
+		mu.Lock()
+		subs = updated
+		mu.Unlock()
+		saved <- updated
+	})
+	s.Client = srv.Client()
+	s.Interval = 50 * time.Millisecond
+	s.Jitter = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	next := func() Subscription {
+		t.Helper()
+		select {
+		case updated := <-saved:
+			return updated[0]
+		case <-time.After(5 * time.Second):
+			t.Fatal("scheduler did not run")
+			return Subscription{}
+		}
+	}
+
+	// First check downloads and applies the rules
+	first := next()
+	if first.LastError != "" || first.LastUpdated.IsZero() || first.ETag != `"v5"` {
+		t.Fatalf("first check: %+v", first.SubscriptionState)
+	}
+	if got := CleanText("https://example.com/?served_param=1&id=2", CleanOptions{}); got != "https://example.com/?id=2" {
+		t.Errorf("downloaded rules not applied: %s", got)
+	}
+
+	// Later checks are conditional
+	second := next()
+	h := srv.lastHeader()
+	if h.Get("If-None-Match") != `"v5"` || h.Get("If-Modified-Since") != "Sun, 18 Oct 2026 08:00:00 GMT" {
+		t.Errorf("conditional headers not sent: %v", h)
+	}
+	if second.LastError != "" || !second.LastUpdated.After(first.LastUpdated) {
+		t.Errorf("304 check: %+v", second.SubscriptionState)
+	}
+
+	// A failing host is recorded and retried with backoff
+	srv.set(testRulesV5, `"v5"`, true)
+	failed := next()
+	if failed.LastError == "" || failed.Failures != 1 || !failed.LastUpdated.Equal(second.LastUpdated) {
+		t.Errorf("failed check: %+v", failed.SubscriptionState)
+	}
+	if got, want := s.due(failed), failed.LastChecked.Add(retryDelay(1, s.Interval)); !got.Equal(want) {
+		t.Errorf("retry due at %v, want %v", got, want)
+	}
+	failed = next()
+	if failed.Failures != 2 {
+		t.Errorf("consecutive failures = %d, want 2", failed.Failures)
+	}
+
+	srv.set(testRulesV5, `"v5"`, false)
+	recovered := next()
+	if recovered.LastError != "" || recovered.Failures != 0 || !recovered.LastUpdated.Equal(recovered.LastChecked) {
+		t.Errorf("recovered check: %+v", recovered.SubscriptionState)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		failures int
+		limit    time.Duration
+		want     time.Duration
+	}{
+		{1, day, minRetryDelay},
+		{2, day, 2 * minRetryDelay},
+		{3, day, 4 * minRetryDelay},
+		{7, day, 64 * minRetryDelay},
+		{8, day, day},
+		{50, day, day},
+		{1, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.failures, tt.limit); got != tt.want {
+			t.Errorf("retryDelay(%d, %v) = %v, want %v", tt.failures, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateSubscriptionsRefusals(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv, pub := newRuleServer(t)
+	_, otherPub := newRuleServer(t)
+	all := func(Subscription) bool { return true }
+
+	// Rules signed by a key that is not trusted
+	srv.set(testRulesV5, `"v5"`, false)
+	subs := []Subscription{{Name: "Stand-in", URL: srv.URL + "/rules.json", Enabled: true}}
+	subs, err := updateSubscriptions(srv.Client(), subs, []string{otherPub}, all)
+	if err == nil || !strings.Contains(subs[0].LastError, "untrusted") || !subs[0].LastUpdated.IsZero() {
+		t.Errorf("untrusted key accepted: %v, %+v", err, subs[0].SubscriptionState)
+	}
+
+	subs, err = updateSubscriptions(srv.Client(), subs, []string{pub}, all)
+	if err != nil || subs[0].LastError != "" {
+		t.Fatalf("trusted update failed: %v", err)
+	}
+
+	// An older version is a replay and must not replace the current rules
+	srv.set(`{"version": 4, "blocklist": ["old_param"]}`, `"v4"`, false)
+	subs, err = updateSubscriptions(srv.Client(), subs, []string{pub}, all)
+	if err == nil || !strings.Contains(subs[0].LastError, "older") {
+		t.Errorf("downgrade accepted: %v", err)
+	}
+	if got := subs[0].cached(); got == nil || got.Version != 5 {
+		t.Errorf("cached rules replaced by downgrade: %+v", got)
+	}
+
+	// Unchanged rules answer 304 and report ErrRulesUpToDate
+	srv.set(testRulesV5, `"v5"`, false)
+	if _, err := updateSubscriptions(srv.Client(), subs, []string{pub}, all); !errors.Is(err, ErrRulesUpToDate) {
+		t.Errorf("unchanged rules: %v", err)
+	}
+}
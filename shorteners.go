@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// defaultShorteners are the link shortening services resolved by "Unshorten
+// Links". Each entry also covers its subdomains.
+var defaultShorteners = []string{
+	"bit.ly", "goo.gl", "t.co", "tinyurl.com", "is.gd", "v.gd",
+	"buff.ly", "ow.ly", "amzn.to", "amzn.eu", "lnkd.in", "rebrand.ly",
+	"shrtco.de", "cutt.ly", "t.ly", "rb.gy", "tiny.cc", "shorturl.at",
+	"trib.al", "dlvr.it",
+}
+
+// Limits of the short-link heuristic: the host and the single path segment
+// of a shortened link are both short.
+const (
+	maxShortHostLen = 10
+	minShortCodeLen = 5
+	maxShortCodeLen = 12
+)
+
+// compileShorteners checks that every entry is a bare host name.
+func compileShorteners(entries []string) ([]string, error) {
+	hosts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := strings.ToLower(strings.TrimSpace(entry))
+		if host == "" || strings.ContainsAny(host, "/:?#@ ") {
+			return nil, fmt.Errorf("invalid shortener %q: expected a host name such as bit.ly", entry)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// shortLink reports whether rawURL should be resolved over the network and
+// why, e.g. "bit.ly in shorteners". Links on hosts missing from the list are
+// only considered when heuristic is set.
+func (rs *ruleSet) shortLink(rawURL string, heuristic bool) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	for _, s := range rs.shorteners {
+		if hostMatches(u.Hostname(), s) {
+			return s + " in shorteners", true
+		}
+	}
+	if heuristic && looksShortened(u) {
+		return "looks like a short link", true
+	}
+	return "", false
+}
+
+// looksShortened guesses whether u comes from an unlisted shortener: a short
+// public host and a single random-looking code as the whole path, such as
+// https://sho.rt/aZ3kQ9.
+func looksShortened(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if len(host) > maxShortHostLen || !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		return false
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	code := strings.TrimPrefix(u.Path, "/")
+	if len(code) < minShortCodeLen || len(code) > maxShortCodeLen {
+		return false
+	}
+
+	// Codes mix digits or cases; plain words like /about or /pricing do not
+	var digit, upper, lower bool
+	for _, r := range code {
+		switch {
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r == '-' || r == '_':
+		default:
+			return false
+		}
+	}
+	return (digit && (upper || lower)) || (upper && lower)
+}
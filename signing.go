@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// releaseKey is the base64 public half of the key the official rules.json is
+// signed with. The maintainer creates it with "go run ./cmd/signrules -genkey"
+// and keeps the private half out of the repository. While it is empty the
+// official subscription is not checked at all (see errSigningNotConfigured),
+// and other subscriptions need a key in trusted_keys.
+const releaseKey = ""
+
+// signatureSuffix names the detached signature published next to a rule
+// file: rules.json is signed by rules.json.sig, a base64 ed25519 signature
+// over the exact bytes of the file.
+const signatureSuffix = ".sig"
+
+// maxSignatureSize caps downloaded signature files.
+const maxSignatureSize = 1 << 10
+
+var (
+	errUntrustedRules = errors.New("signature does not match any trusted key")
+	errNoSigningKey   = errors.New("no valid signing key is configured; set releaseKey or add trusted_keys")
+	// errSigningNotConfigured skips the official subscription in builds
+	// without a release key, instead of failing and retrying it.
+	errSigningNotConfigured = errors.New("official rule updates are not signed in this build")
+)
+
+// parsePublicKey decodes a base64 ed25519 public key.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %q", strings.TrimSpace(s))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// verifyRules checks sig against data with the release key and every key in
+// trustedKeys. Keys that do not parse are skipped, so one bad trusted_keys
+// entry does not block updates signed by the others.
+func verifyRules(data, sig []byte, trustedKeys []string) error {
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	}
+	keys := trustedKeys
+	if releaseKey != "" {
+		keys = append([]string{releaseKey}, trustedKeys...)
+	}
+	valid := 0
+	for _, k := range keys {
+		key, err := parsePublicKey(k)
+		if err != nil {
+			continue
+		}
+		valid++
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	}
+	if valid == 0 {
+		return errNoSigningKey
+	}
+	return errUntrustedRules
+}
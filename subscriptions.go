@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// userRulesFileName holds the user's own rules. PureLink creates it once and
+// never writes to it again, so hand-added params survive every update.
+const userRulesFileName = "user_rules.json"
+
+// Subscription is a rule source: an http(s) URL that is downloaded by
+// "Check for Filter Updates", or a local file that is read in place.
+type Subscription struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+	SubscriptionState
+}
+
+// SubscriptionState is what PureLink records about a subscription each time
+// it is updated.
+type SubscriptionState struct {
+	// LastUpdated is the last successful check, LastChecked the last attempt.
+	LastUpdated time.Time `json:"last_updated,omitzero"`
+	LastChecked time.Time `json:"last_checked,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Failures counts consecutive failed updates, for backing off.
+	Failures int `json:"failures,omitempty"`
+	// ETag and LastModified validate the cached download on the next request.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Changes is what the last modifying update, at LastChanged, added and removed.
+	LastChanged time.Time `json:"last_changed,omitzero"`
+	Changes     RuleDiff  `json:"changes,omitzero"`
+	// SkipVersion is a version the user rolled back from; it is not applied again.
+	// SkipHash does the same by content for rules without a version.
+	SkipVersion int64  `json:"skip_version,omitempty"`
+	SkipHash    string `json:"skip_hash,omitempty"`
+}
+
+// defaultSubscriptions returns the official PureLink rules.
+func defaultSubscriptions() []Subscription {
+	return []Subscription{{Name: "PureLink", URL: updateURL, Enabled: true}}
+}
+
+func (s *Subscription) official() bool {
+	return s.URL == updateURL
+}
+
+// sourceName names the subscription in rule descriptions; the official
+// rules are the unnamed default.
+func (s *Subscription) sourceName() string {
+	if s.official() {
+		return ""
+	}
+	return s.Name
+}
+
+func (s *Subscription) remote() bool {
+	return hasHTTPPrefix(s.URL)
+}
+
+// file is where the subscription's rules are read from: the local path
+// itself, or the cached download. The official rules keep using rules.json.
+func (s *Subscription) file() string {
+	switch {
+	case s.official():
+		return rulesFileName
+	case !s.remote():
+		return s.URL
+	}
+	sum := sha256.Sum256([]byte(s.URL))
+	return fmt.Sprintf("rules_%x.json", sum[:6])
+}
+
+// Status describes the subscription for the tray.
+func (s *Subscription) Status() string {
+	switch {
+	case !s.Enabled:
+		return "Disabled"
+	case s.LastError != "":
+		return "Error: " + s.LastError
+	case !s.remote():
+		return "Local file " + s.URL
+	case s.official() && releaseKey == "":
+		return "Built-in rules (updates are not signed in this build)"
+	case s.LastUpdated.IsZero():
+		return "Never updated"
+	}
+	return "Updated " + s.LastUpdated.Format("2006-01-02 15:04")
+}
+
+// load reads the subscription's rules. The official subscription falls back
+// to the built-in rules, writing them to rules.json on first launch, and
+// migrates a rules.json left in the legacy format, keeping a backup.
+func (s *Subscription) load() (*RuleConfig, error) {
+	name := s.file()
+	if s.official() {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if err := saveRulesToFile(name, defaultRuleConfig()); err != nil {
+				return defaultRuleConfig(), err
+			}
+		}
+	}
+
+	data, err := os.ReadFile(name)
+	if err == nil {
+		var config *RuleConfig
+		if config, err = parseRules(data); err == nil {
+			if migrated, ok := migrateLegacyRules(config); ok && s.official() {
+				if err := backupRules(name); err != nil {
+					return migrated, err
+				}
+				return migrated, saveRulesToFile(name, migrated)
+			}
+			return config, nil
+		}
+	}
+	if s.official() {
+		return defaultRuleConfig(), err
+	}
+	return nil, err
+}
+
+// update fetches and checks a new version of the subscription's rules and
+// reports whether they differ from the ones in use.
+func (s *Subscription) update(client *http.Client, trustedKeys []string) (bool, error) {
+	if !s.remote() {
+		// Local files are read in place; only make sure they still work and
+		// report whether they changed since they were last loaded
+		config, err := s.load()
+		if err != nil {
+			return false, fmt.Errorf("invalid rule format: %v", err)
+		}
+		if err := testRules(config); err != nil {
+			return false, err
+		}
+		return ruleHash(config) != loadedHash(s.file()), nil
+	}
+
+	if s.official() && releaseKey == "" {
+		return false, errSigningNotConfigured
+	}
+
+	// Ask only for changes, unless the cached copy is gone
+	v := &validators{}
+	if _, err := os.Stat(s.file()); err == nil {
+		v.etag, v.lastModified = s.ETag, s.LastModified
+	}
+	data, err := download(client, s.URL, maxRulesSize, v)
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil // Not modified
+	}
+
+	sig, err := download(client, s.URL+signatureSuffix, maxSignatureSize, nil)
+	if err != nil {
+		return false, fmt.Errorf("rules are not signed: %v", err)
+	}
+	if err := verifyRules(data, sig, trustedKeys); err != nil {
+		return false, fmt.Errorf("untrusted rules: %v", err)
+	}
+
+	// Decode to verify validity (PureLink or ClearURLs format)
+	newConfig, err := parseRules(data)
+	if err != nil {
+		return false, fmt.Errorf("invalid rule format: %v", err)
+	}
+
+	if len(newConfig.Blocklist) == 0 && len(newConfig.Rules) == 0 {
+		return false, fmt.Errorf("downloaded rules are empty")
+	}
+
+	// Refuse replayed older rule sets
+	cached := s.cached()
+	var current int64
+	if cached != nil {
+		current = cached.Version
+	}
+	if newConfig.Version < current {
+		return false, fmt.Errorf("downloaded rules (version %d) are older than the current ones (version %d)", newConfig.Version, current)
+	}
+	if newConfig.Version != 0 && (newConfig.Version == current || newConfig.Version == s.SkipVersion) ||
+		newConfig.Version == 0 && s.SkipHash != "" && ruleHash(newConfig) == s.SkipHash {
+		s.ETag, s.LastModified = v.etag, v.lastModified
+		return false, nil
+	}
+
+	// Run the self-tests shipped with the rules before replacing the current ones
+	if err := testRules(newConfig); err != nil {
+		return false, fmt.Errorf("new rules rejected, keeping the current ones.\n%v", err)
+	}
+
+	// Keep the previous version for "Roll Back Rules", then save to disk
+	if err := backupRules(s.file()); err != nil {
+		return false, fmt.Errorf("failed to back up rules: %v", err)
+	}
+	if err := saveRulesToFile(s.file(), newConfig); err != nil {
+		return false, fmt.Errorf("failed to save rules: %v", err)
+	}
+	// Until a first download, the subscription's rules are the built-in ones
+	if cached == nil {
+		cached = defaultRuleConfig()
+	}
+	s.ETag, s.LastModified = v.etag, v.lastModified
+	s.Changes = diffRules(cached, newConfig)
+	s.LastChanged = s.LastChecked
+	s.SkipVersion, s.SkipHash = 0, ""
+	return true, nil
+}
+
+// cached returns the rules last downloaded for s, or nil.
+func (s *Subscription) cached() *RuleConfig {
+	data, err := os.ReadFile(s.file())
+	if err != nil {
+		return nil
+	}
+	config, err := parseRules(data)
+	if err != nil {
+		return nil
+	}
+	return config
+}
+
+// JustChanged reports whether the last check modified the rules.
+func (s *Subscription) JustChanged() bool {
+	return !s.LastChanged.IsZero() && s.LastChanged.Equal(s.LastChecked)
+}
+
+// loadUserRules reads user_rules.json, creating an empty one on first launch.
+func loadUserRules() (*RuleConfig, error) {
+	if _, err := os.Stat(userRulesFileName); os.IsNotExist(err) {
+		empty := &RuleConfig{Blocklist: []string{}, Rules: []ScopedRule{}}
+		return empty, saveRulesToFile(userRulesFileName, empty)
+	}
+	data, err := os.ReadFile(userRulesFileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(data)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const unshortenCacheFileName = "unshorten_cache.json"
+
+// Unshorten cache limits. Short links rarely change their target, so
+// resolutions are kept for a month; failures are retried after an hour.
+const (
+	resolvedCacheTTL    = 30 * 24 * time.Hour
+	failedCacheTTL      = time.Hour
+	maxUnshortenEntries = 1000
+)
+
+// cachedResolution is the outcome of resolving one short link.
+type cachedResolution struct {
+	Resolved string `json:"resolved,omitempty"`
+	// Blocked is the private address the link led to.
+	Blocked string `json:"blocked,omitempty"`
+	// Error describes why resolving failed.
+	Error  string    `json:"error,omitempty"`
+	Stored time.Time `json:"stored"`
+}
+
+func newCachedResolution(resolved string, err error, now time.Time) cachedResolution {
+	r := cachedResolution{Resolved: resolved, Stored: now}
+	var blocked *errBlockedAddress
+	if errors.As(err, &blocked) {
+		r.Blocked = blocked.addr.String()
+	} else if err != nil {
+		r.Error = redactSecrets(err.Error())
+	}
+	return r
+}
+
+// result returns what resolveURL returned when the entry was stored.
+func (r cachedResolution) result() (string, error) {
+	if addr, err := netip.ParseAddr(r.Blocked); err == nil {
+		return "", &errBlockedAddress{addr}
+	}
+	if r.Error != "" {
+		return "", errors.New(r.Error)
+	}
+	return r.Resolved, nil
+}
+
+func (r cachedResolution) expired(now time.Time) bool {
+	ttl := resolvedCacheTTL
+	if r.Resolved == "" {
+		ttl = failedCacheTTL
+	}
+	return now.Sub(r.Stored) >= ttl
+}
+
+// unshortenCache maps short links to their resolutions, so a link copied
+// again is neither delayed nor reported to the shortener a second time. It
+// is read from file on first use and written back after every change.
+type unshortenCache struct {
+	mu      sync.Mutex
+	file    string
+	entries map[string]cachedResolution
+}
+
+var shortLinkCache = &unshortenCache{file: unshortenCacheFileName}
+
+// load reads the cache file once. A missing or broken file starts an empty cache.
+// Callers must hold c.mu.
+func (c *unshortenCache) load() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = make(map[string]cachedResolution)
+	if data, err := os.ReadFile(c.file); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+}
+
+// lookup returns the unexpired resolution of shortURL. A link once blocked
+// is resolved again if its address has since been allowed.
+func (c *unshortenCache) lookup(shortURL string, allowed []netip.Prefix, now time.Time) (cachedResolution, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	r, ok := c.entries[shortURL]
+	if !ok || r.expired(now) {
+		return r, false
+	}
+	if addr, err := netip.ParseAddr(r.Blocked); err == nil && publicAddress(addr, allowed) {
+		return r, false
+	}
+	return r, true
+}
+
+// store records r, dropping expired entries and then the oldest ones to
+// stay within maxUnshortenEntries.
+func (c *unshortenCache) store(shortURL string, r cachedResolution) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	c.entries[shortURL] = r
+
+	for key, entry := range c.entries {
+		if entry.expired(r.Stored) {
+			delete(c.entries, key)
+		}
+	}
+	if excess := len(c.entries) - maxUnshortenEntries; excess > 0 {
+		keys := slices.SortedFunc(maps.Keys(c.entries), func(a, b string) int {
+			return c.entries[a].Stored.Compare(c.entries[b].Stored)
+		})
+		for _, key := range keys[:excess] {
+			delete(c.entries, key)
+		}
+	}
+	return c.save()
+}
+
+// clear forgets every entry and returns how many there were.
+func (c *unshortenCache) clear() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	n := len(c.entries)
+	c.entries = make(map[string]cachedResolution)
+	if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+		return n, err
+	}
+	return n, nil
+}
+
+// save writes the cache through a temporary file.
+// Callers must hold c.mu.
+func (c *unshortenCache) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// errNotCached is returned by resolveCached for an uncached link when offline.
+var errNotCached = errors.New("short link is not in the unshorten cache")
+
+// resolveCached is resolveURL answered from the unshorten cache when
+// possible, or only from the cache when offline is set. Failures are cached
+// too, so a dead link is not retried on every copy; cancelled lookups are not,
+// and neither are links carrying credentials, which must never reach the file.
+func resolveCached(ctx context.Context, shortURL string, allowed []netip.Prefix, offline bool) (string, error) {
+	now := time.Now()
+	if r, ok := shortLinkCache.lookup(shortURL, allowed, now); ok {
+		return r.result()
+	}
+	if offline {
+		return "", errNotCached
+	}
+	resolved, err := resolveURL(ctx, shortURL, allowed)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if !hasSecrets(shortURL) && !hasSecrets(resolved) {
+		shortLinkCache.store(shortURL, newCachedResolution(resolved, err, now))
+	}
+	return resolved, err
+}
+
+// ClearUnshortenCache forgets every cached short link and returns how many
+// there were.
+func ClearUnshortenCache() (int, error) {
+	return shortLinkCache.clear()
+}
@@ -1,50 +1,127 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 )
 
 const updateURL = "https://raw.githubusercontent.com/ahmedthebest31/PureLink/main/rules.json"
 
-// UpdateFilters downloads the latest rules from the repository and updates the local configuration.
-func UpdateFilters() error {
+// maxRulesSize caps downloaded rule files; the full ClearURLs database is well below it.
+const maxRulesSize = 8 << 20
+
+// ErrRulesUpToDate is returned by UpdateFilters when no subscription had
+// newer rules.
+var ErrRulesUpToDate = errors.New("filters are already up to date")
+
+// updateMutex keeps manual and scheduled updates from overlapping.
+var updateMutex sync.Mutex
+
+// UpdateFilters downloads the latest rules of every enabled subscription and
+// reloads the active rules. Downloaded rules must carry a valid signature from
+// the release key or one of trustedKeys, and must not be older than the ones
+// they replace. It returns subs with their status updated; a failing
+// subscription keeps its previous rules and does not stop the others.
+func UpdateFilters(subs []Subscription, trustedKeys []string) ([]Subscription, error) {
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
+	return updateSubscriptions(client, subs, trustedKeys, func(Subscription) bool { return true })
+}
 
-	resp, err := client.Get(updateURL)
-	if err != nil {
-		return fmt.Errorf("network error: %v", err)
-	}
-	defer resp.Body.Close()
+// updateSubscriptions is UpdateFilters limited to the enabled subscriptions
+// for which due returns true.
+func updateSubscriptions(client *http.Client, subs []Subscription, trustedKeys []string, due func(Subscription) bool) ([]Subscription, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+	subs = slices.Clone(subs)
+	var errs []error
+	changed := false
+	for i := range subs {
+		sub := &subs[i]
+		if !sub.Enabled || !due(*sub) {
+			continue
+		}
+		sub.LastChecked = time.Now()
+		updated, err := sub.update(client, trustedKeys)
+		if errors.Is(err, errSigningNotConfigured) {
+			// Nothing to check, which is not a failure to retry
+			sub.LastError = ""
+			sub.Failures = 0
+			continue
+		}
+		if err != nil {
+			sub.LastError = err.Error()
+			sub.Failures++
+			errs = append(errs, fmt.Errorf("%s: %v", sub.Name, err))
+			continue
+		}
+		sub.LastError = ""
+		sub.Failures = 0
+		sub.LastUpdated = sub.LastChecked
+		changed = changed || updated
 	}
 
-	// Decode to verify validity
-	var newConfig RuleConfig
-	decoder := json.NewDecoder(resp.Body)
-	if err := decoder.Decode(&newConfig); err != nil {
-		return fmt.Errorf("invalid rule format: %v", err)
+	if changed {
+		// Reload into memory; the new rule set replaces the old one in a single swap
+		if err := LoadRules(subs); err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply new rules: %v", err))
+		}
+	} else if len(errs) == 0 {
+		return subs, ErrRulesUpToDate
 	}
+	return subs, errors.Join(errs...)
+}
+
+// validators are the HTTP cache validators of a previous download.
+type validators struct {
+	etag         string
+	lastModified string
+}
 
-	if len(newConfig.Blocklist) == 0 {
-		return fmt.Errorf("downloaded rules are empty")
+// download fetches url, reading at most limit bytes of the body. With
+// validators it makes a conditional request, returns nil data when the
+// server answers 304 Not Modified, and records the new validators.
+func download(client *http.Client, url string, limit int64, v *validators) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
 	}
 
-	// Save to disk
-	if err := saveRulesToFile(&newConfig); err != nil {
-		return fmt.Errorf("failed to save rules: %v", err)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Reload into memory
-	if err := LoadRules(); err != nil {
-		return fmt.Errorf("failed to apply new rules: %v", err)
+	if v != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
 	}
 
-	return nil
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, fmt.Errorf("network error: %v", err)
+	}
+	if v != nil {
+		v.etag = resp.Header.Get("ETag")
+		v.lastModified = resp.Header.Get("Last-Modified")
+	}
+	return data, nil
 }
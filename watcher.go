@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+)
+
+// File watching timing. Editors often save in several steps, so changes are
+// only reported once a file has been quiet for watchDebounce.
+const (
+	watchInterval = time.Second
+	watchDebounce = 500 * time.Millisecond
+)
+
+// fileWatcher polls files for changes. Polling the size and modification
+// time works the same on every platform the tray runs on.
+type fileWatcher struct {
+	interval time.Duration
+	debounce time.Duration
+	// files returns the files to watch; it is called on every poll, so the
+	// list can follow configuration changes.
+	files func() []string
+	// onChange receives the files that changed since the last call.
+	onChange func(changed []string)
+
+	seen    map[string]fileStamp
+	pending []string
+	lastHit time.Time
+}
+
+// fileStamp identifies one version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(files func() []string, onChange func([]string)) *fileWatcher {
+	return &fileWatcher{
+		interval: watchInterval,
+		debounce: watchDebounce,
+		files:    files,
+		onChange: onChange,
+	}
+}
+
+// Run polls until ctx is cancelled. Files present when it starts are not
+// reported.
+func (w *fileWatcher) Run(ctx context.Context) {
+	w.seen = make(map[string]fileStamp)
+	w.poll(time.Now())
+	w.pending = nil
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.poll(now)
+			if len(w.pending) > 0 && now.Sub(w.lastHit) >= w.debounce {
+				changed := w.pending
+				w.pending = nil
+				w.onChange(changed)
+			}
+		}
+	}
+}
+
+// poll records every file whose stamp differs from the last poll.
+func (w *fileWatcher) poll(now time.Time) {
+	for _, name := range w.files() {
+		var stamp fileStamp
+		if info, err := os.Stat(name); err == nil {
+			stamp = fileStamp{info.ModTime(), info.Size()}
+		}
+		if old, ok := w.seen[name]; ok && old == stamp {
+			continue
+		}
+		w.seen[name] = stamp
+		if !slices.Contains(w.pending, name) {
+			w.pending = append(w.pending, name)
+		}
+		w.lastHit = now
+	}
+}